@@ -0,0 +1,190 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main lists images that the prefetch suite pulls for the given sources.
+//
+// Usage:
+//
+//	prefetch-images [flags] [sources...]
+//
+// If no sources are passed, external-images.yaml and apps of the deployments repository are used for the -ref.
+// For example, to preload a kind cluster:
+//
+//	prefetch-images -ref v1.2.0 | xargs -n1 kind load docker-image
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+type options struct {
+	repository   string
+	ref          string
+	files        string
+	excludeFiles string
+	include      string
+	exclude      string
+	filterFile   string
+	mirrors      string
+	rewrites     string
+	format       string
+	normalize    bool
+	strict       bool
+	githubToken  string
+	cacheDir     string
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.repository, "repo", "networkservicemesh/deployments-k8s", "github repository used if no sources are passed")
+	flag.StringVar(&o.ref, "ref", "main", "ref of the github repository used if no sources are passed")
+	flag.StringVar(&o.files, "files", `\.yaml$`, "regex of file paths to search images in")
+	flag.StringVar(&o.excludeFiles, "exclude-files", "", "regex of file paths to skip")
+	flag.StringVar(&o.include, "include", "", "include rule, a regex of images or of file paths with the path: prefix, all images are listed if empty")
+	flag.StringVar(&o.exclude, "exclude", images.DefaultExclude, "exclude rule, a regex of images and file paths, use the image: or the path: prefix to match only one of them")
+	flag.StringVar(&o.filterFile, "filter-file", "", "file with rules, each line is include or exclude followed by the rule")
+	flag.StringVar(&o.mirrors, "mirrors", "", "comma separated image mirrors in the prefix=mirror form, e.g. docker.io=localhost:5000/dockerhub")
+	flag.StringVar(&o.rewrites, "rewrites", "", "comma separated image rewrite rules in the regex=replacement form")
+	flag.StringVar(&o.format, "format", "text", "output format: text, json or yaml")
+	flag.BoolVar(&o.normalize, "normalize", false, "print normalized image references, e.g. docker.io/library/alpine:latest for alpine")
+	flag.BoolVar(&o.strict, "strict", false, "fail if any source cannot be read")
+	flag.StringVar(&o.githubToken, "github-token", os.Getenv("GITHUB_TOKEN"), "token for github API requests")
+	flag.StringVar(&o.cacheDir, "cache-dir", "", "directory for caching remote sources, caching is disabled if empty")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	list, err := reterive(ctx, &o, flag.Args())
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+
+	if err := write(os.Stdout, o.format, list); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
+
+func reterive(ctx context.Context, o *options, sources []string) (*images.ImageList, error) {
+	if len(sources) == 0 {
+		sources = images.GithubSourcesURLs(o.repository, o.ref, images.ExternalImagesPath, images.AppsPath)
+	}
+
+	filter, err := images.NewFilter(o.include, o.exclude, o.filterFile)
+	if err != nil {
+		return nil, err
+	}
+	rewriter, err := images.NewRewriter(splitList(o.mirrors), splitList(o.rewrites))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := regexp.Compile(o.files)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid -files")
+	}
+	var excludeFiles *regexp.Regexp
+	if o.excludeFiles != "" {
+		if excludeFiles, err = regexp.Compile(o.excludeFiles); err != nil {
+			return nil, errors.Wrap(err, "invalid -exclude-files")
+		}
+	}
+	var match = func(name string) bool {
+		return files.MatchString(name) && (excludeFiles == nil || !excludeFiles.MatchString(name)) && filter.MatchPath(name)
+	}
+
+	var opts = []images.Option{images.WithGithubToken(o.githubToken)}
+	if o.cacheDir != "" {
+		opts = append(opts, images.WithCacheDir(o.cacheDir))
+	}
+
+	list, err := images.ReteriveListContext(ctx, sources, match, opts...)
+	if err != nil {
+		if o.strict {
+			return nil, err
+		}
+		logrus.Warn(err.Error())
+	}
+
+	return normalize(o, rewriter.RewriteAll(filter.FilterImages(images.Deduplicate(list.Images))))
+}
+
+func normalize(o *options, list []string) (*images.ImageList, error) {
+	var result = new(images.ImageList)
+	for _, image := range list {
+		if o.normalize {
+			ref, err := images.ParseReference(image)
+			if err != nil {
+				return nil, err
+			}
+			image = ref.String()
+		}
+		result.Images = append(result.Images, image)
+	}
+	return result, nil
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func write(w io.Writer, format string, list *images.ImageList) error {
+	switch strings.ToLower(format) {
+	case "text":
+		for _, image := range list.Images {
+			if _, err := fmt.Fprintln(w, image); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		var encoder = json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	case "yaml":
+		var encoder = yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if _, err := io.WriteString(w, "---\n"); err != nil {
+			return err
+		}
+		if err := encoder.Encode(list); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return errors.Errorf("unknown format: %v", format)
+	}
+}
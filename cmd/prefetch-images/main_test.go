@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func samplesURL(t *testing.T) string {
+	dir, err := filepath.Abs(filepath.Join("..", "..", "extensions", "prefetch", "images", "samples"))
+	require.NoError(t, err)
+	return "file://" + dir
+}
+
+func TestReterive(t *testing.T) {
+	for name, test := range map[string]struct {
+		options  options
+		expected []string
+	}{
+		"all": {
+			options: options{files: `\.yaml$`},
+			expected: []string{
+				"alpine",
+				"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+				"ghcr.io/networkservicemesh/cmd-nsc:v1.1.0",
+				"docker.io/library/alpine:3.15",
+				"image1",
+				"image2",
+				"nginx:1.21",
+				"busybox:1.34",
+				"curlimages/curl",
+			},
+		},
+		"filtered": {
+			options:  options{files: `\.yaml$`, excludeFiles: "kustomize", include: "image:^docker.io/library/", exclude: "image:alpine"},
+			expected: []string{"image1", "image2", "nginx:1.21", "busybox:1.34"},
+		},
+		"mirrored and normalized": {
+			options:  options{files: `\.yaml$`, include: "alpine", mirrors: "docker.io=mirror.io", normalize: true},
+			expected: []string{"mirror.io/library/alpine:latest", "mirror.io/library/alpine:3.15"},
+		},
+	} {
+		var test = test
+		t.Run(name, func(t *testing.T) {
+			list, err := reterive(context.Background(), &test.options, []string{samplesURL(t)})
+			require.NoError(t, err)
+			require.Equal(t, test.expected, list.Images)
+		})
+	}
+}
+
+func TestReterive_Invalid(t *testing.T) {
+	_, err := reterive(context.Background(), &options{files: "("}, []string{samplesURL(t)})
+	require.Error(t, err)
+
+	_, err = reterive(context.Background(), &options{files: `\.yaml$`, exclude: "("}, []string{samplesURL(t)})
+	require.Error(t, err)
+
+	_, err = reterive(context.Background(), &options{files: `\.yaml$`, strict: true}, []string{"file:///not/found"})
+	require.Error(t, err)
+}
+
+func TestWrite(t *testing.T) {
+	var list = &images.ImageList{Images: []string{"alpine", "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}}
+
+	for format, expected := range map[string]string{
+		"text": "alpine\nghcr.io/networkservicemesh/cmd-nsc:v1.0.0\n",
+		"JSON": "{\n  \"images\": [\n    \"alpine\",\n    \"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0\"\n  ]\n}\n",
+		"yaml": "---\nimages:\n- alpine\n- ghcr.io/networkservicemesh/cmd-nsc:v1.0.0\n",
+	} {
+		var sb strings.Builder
+		require.NoError(t, write(&sb, format, list), format)
+		require.Equal(t, expected, sb.String(), format)
+	}
+
+	require.Error(t, write(new(strings.Builder), "xml", list))
+}
+
+// The json and yaml outputs are compatible with the ImageList, so they can be used as the prefetch sources.
+func TestWrite_ImageList(t *testing.T) {
+	var list = &images.ImageList{Images: []string{"alpine", "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}}
+
+	var sb strings.Builder
+	require.NoError(t, write(&sb, "json", list))
+	var result images.ImageList
+	require.NoError(t, json.Unmarshal([]byte(sb.String()), &result))
+	require.Equal(t, list.Images, result.Images)
+
+	var file = filepath.Join(t.TempDir(), "images.yaml")
+	sb.Reset()
+	require.NoError(t, write(&sb, "yaml", list))
+	require.NoError(t, ioutil.WriteFile(file, []byte(sb.String()), 0600))
+	require.Equal(t, list.Images, images.ReteriveList([]string{"file://" + file}, func(string) bool { return true }).Images)
+}
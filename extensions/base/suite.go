@@ -18,7 +18,6 @@
 package base
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/networkservicemesh/gotestmd/pkg/suites/shell"
@@ -41,9 +40,13 @@ func (s *Suite) AfterTest(_, _ string) {
 	s.storeTestLogs()
 }
 
-// BeforeTest starts capture logs for each test in the suite.
-func (s *Suite) BeforeTest(_, _ string) {
+// BeforeTest starts capture logs for each test in the suite and waits for the images of the test if they are
+// prefetched in the background.
+func (s *Suite) BeforeTest(suiteName, testName string) {
 	s.storeTestLogs = logs.Capture(s.T().Name())
+
+	s.prefetch.SetT(s.T())
+	s.prefetch.BeforeTest(suiteName, testName)
 }
 
 // TearDownSuite stores logs from containers that spawned during SuiteSetup.
@@ -73,14 +76,13 @@ func (s *Suite) SetupSuite() {
 	s.checkout.SetupSuite()
 
 	// prefetch
-	s.prefetch.SourcesURLs = []string{
-		// Note: use urls for local image files.
-		// For example:
-		//    "file://my-debug-images-for-prefetch.yaml"
-		//    "file://deployments-k8s/apps/"
-		fmt.Sprintf("https://raw.githubusercontent.com/%v/%v/external-images.yaml", repo, version),
-		fmt.Sprintf("https://api.github.com/repos/%v/contents/apps?ref=%v", repo, version),
-	}
+	// Note: set s.prefetch.SourcesURLs to use urls for local image files instead of the profile sources.
+	// For example:
+	//    "file://my-debug-images-for-prefetch.yaml"
+	//    "file://deployments-k8s/apps/"
+	s.prefetch.Repository = repo
+	s.prefetch.Version = version
+	s.prefetch.Dir = s.checkout.RepositoryDir()
 
 	s.prefetch.SetT(s.T())
 	s.prefetch.SetupSuite()
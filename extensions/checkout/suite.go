@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -32,6 +32,7 @@ type Suite struct {
 	Repository string
 	Dir        string
 	Version    string
+	repoDir    string
 }
 
 const urlFormat = "https://github.com/%v.git"
@@ -41,11 +42,16 @@ func (s *Suite) SetupSuite() {
 	r := s.Runner(s.Dir)
 	u := fmt.Sprintf(urlFormat, s.Repository)
 	_, dir := path.Split(s.Repository)
-	repoDir := filepath.Join(r.Dir(), dir)
+	s.repoDir = filepath.Join(r.Dir(), dir)
 	// #nosec
-	if _, err := os.Open(repoDir); err != nil {
+	if _, err := os.Open(s.repoDir); err != nil {
 		r.Run("git clone " + u)
-		r.Run("cd " + repoDir)
+		r.Run("cd " + s.repoDir)
 		r.Run("git checkout " + s.Version)
 	}
 }
+
+// RepositoryDir returns the local directory of the repository, it is known after SetupSuite.
+func (s *Suite) RepositoryDir() string {
+	return s.repoDir
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+// background is the prefetch running in the background. Groups of the plan are prefetched in order, tests wait
+// only for the groups with their images.
+type background struct {
+	// tests are images of the tests by the test name.
+	tests  map[string][]string
+	groups [][]string
+	done   []chan struct{}
+	errs   []error
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// prefetchInBackground starts prefetching the groups of the plan in order, at most parallelism groups at once.
+// finish is called with the result when all groups are done.
+func (p *prefetcher) prefetchInBackground(plan [][]string, parallelism int, finish func(error)) (*background, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	groups, err := p.workloads(ctx, plan)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var b = &background{groups: plan, done: make([]chan struct{}, len(plan)), errs: make([]error, len(plan)), cancel: cancel}
+	for i := range b.done {
+		b.done[i] = make(chan struct{})
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		var slots = make(chan struct{}, parallelism)
+		var wg sync.WaitGroup
+		for i := range groups {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var err = runAll(groups[i])
+				// Groups cancelled by stop are not failed, tests don't wait for them anymore
+				if ctx.Err() == nil {
+					b.errs[i] = err
+				}
+				close(b.done[i])
+				<-slots
+			}(i)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			finish(errors.Wrap(ctx.Err(), "prefetch is stopped"))
+			return
+		}
+		var errs images.Errors
+		for _, err := range b.errs {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			finish(errs)
+			return
+		}
+		finish(nil)
+	}()
+	return b, nil
+}
+
+// wait waits until the groups with the images matching the predicate are prefetched. Groups cancelled by stop are
+// not waited for and not reported as failed.
+func (b *background) wait(ctx context.Context, match func(image string) bool) error {
+	var errs images.Errors
+	for i, group := range b.groups {
+		if !containsMatch(group, match) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "failed to wait for the prefetch")
+		case <-b.done[i]:
+		}
+		if b.errs[i] != nil {
+			errs = append(errs, b.errs[i])
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// stop cancels the prefetch and waits until the workloads are deleted.
+func (b *background) stop() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+func containsMatch(list []string, match func(image string) bool) bool {
+	for _, image := range list {
+		if match(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageMatcher returns the predicate matching the images by the normalized reference.
+func imageMatcher(list []string) func(image string) bool {
+	var keys = map[string]bool{}
+	for _, image := range list {
+		keys[imageKeys(image)[0]] = true
+	}
+	return func(image string) bool {
+		return keys[imageKeys(image)[0]]
+	}
+}
+
+// prioritize orders the images by the first matching priority regex, then by the first test using them in the order
+// testify runs the tests. Other images go last.
+func prioritize(list, priority []string, tests map[string][]string) ([]string, error) {
+	var patterns []*regexp.Regexp
+	for _, p := range priority {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid priority %v", p)
+		}
+		patterns = append(patterns, re)
+	}
+
+	var names []string
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var firstTest = map[string]int{}
+	for i := len(names) - 1; i >= 0; i-- {
+		for _, image := range tests[names[i]] {
+			firstTest[imageKeys(image)[0]] = i
+		}
+	}
+
+	type rank struct{ pattern, test int }
+	var ranks = map[string]rank{}
+	for _, image := range list {
+		var r = rank{pattern: len(patterns), test: len(names)}
+		for i, re := range patterns {
+			if re.MatchString(image) {
+				r.pattern = i
+				break
+			}
+		}
+		if i, ok := firstTest[imageKeys(image)[0]]; ok {
+			r.test = i
+		}
+		ranks[image] = r
+	}
+
+	var result = append([]string(nil), list...)
+	sort.SliceStable(result, func(i, j int) bool {
+		var a, b = ranks[result[i]], ranks[result[j]]
+		return a.pattern < b.pattern || a.pattern == b.pattern && a.test < b.test
+	})
+	return result, nil
+}
+
+// orderGroups orders the groups of the plan by the highest priority of their images in the ordered list.
+func orderGroups(plan [][]string, ordered []string) [][]string {
+	var index = map[string]int{}
+	for i, image := range ordered {
+		index[image] = i
+	}
+	var first = func(group []string) int {
+		var result = len(ordered)
+		for _, image := range group {
+			if i, ok := index[image]; ok && i < result {
+				result = i
+			}
+		}
+		return result
+	}
+
+	var result = append([][]string(nil), plan...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return first(result[i]) < first(result[j])
+	})
+	return result
+}
+
+// testImages returns filtered and rewritten images applied by each test of the local repository matching the selector
+// by the test name, all tests match the empty selector. Tests are keyed by testKey, so tests with the same name in
+// different suites don't overwrite each other. Returns nil if the local repository doesn't exist.
+func (s *Suite) testImages(ctx context.Context, selector testSelector, f *images.Filter, r *images.Rewriter) map[string][]string {
+	if s.Dir == "" {
+		return nil
+	}
+	examples, err := parseExamples(s.Dir)
+	if err != nil {
+		logrus.Warnf("Tests will wait for all images: %v", err.Error())
+		return nil
+	}
+
+	var dirImages = map[string][]string{}
+	var result = map[string][]string{}
+	for _, e := range examples {
+		var name = "Test" + e.title()
+		if !e.isLeaf() || len(selector) > 0 && !selector.match(name) {
+			continue
+		}
+		var w = newDirsWalker(examples)
+		w.visit(e, true)
+		var list []string
+		for _, dir := range w.sortedDirs() {
+			if _, ok := dirImages[dir]; !ok {
+				dirImages[dir] = s.dirImages(ctx, dir, f, r)
+			}
+			list = append(list, dirImages[dir]...)
+		}
+		list = images.Deduplicate(list)
+		if len(e.parents) == 0 {
+			result[testKey("", name)] = list
+		}
+		for _, parent := range e.parents {
+			result[testKey(examples[parent].title(), name)] = list
+		}
+	}
+	return result
+}
+
+// testKey returns the key of the test of the suite, gotestmd generates the suite from the example with includes.
+func testKey(suiteTitle, testName string) string {
+	if suiteTitle == "" {
+		return testName
+	}
+	return suiteTitle + "/" + testName
+}
+
+// testImages returns images of the test run by the go test with the path, e.g. TestRunBasicSuite/TestMemif. If the suite
+// of the test is unknown, returns images of all tests with the name.
+func (b *background) testImages(testPath, testName string) ([]string, bool) {
+	var levels = strings.Split(testPath, "/")
+	for i := len(levels) - 2; i >= 0; i-- {
+		var suiteTitle = strings.TrimSuffix(strings.TrimPrefix(levels[i], "TestRun"), "Suite")
+		if list, ok := b.tests[testKey(suiteTitle, testName)]; ok {
+			return list, true
+		}
+	}
+
+	var result []string
+	var found bool
+	for key, list := range b.tests {
+		if key == testName || strings.HasSuffix(key, "/"+testName) {
+			result, found = append(result, list...), true
+		}
+	}
+	return images.Deduplicate(result), found
+}
+
+func (s *Suite) dirImages(ctx context.Context, dir string, f *images.Filter, r *images.Rewriter) []string {
+	rel, err := filepath.Rel(s.Dir, dir)
+	if err != nil {
+		return nil
+	}
+	var p = &profile{paths: []string{filepath.ToSlash(rel)}}
+	list, _ := images.ReteriveListContext(ctx, p.localSourcesURLs(s.Dir), func(path string) bool {
+		return strings.HasSuffix(path, ".yaml") && f.MatchPath(path)
+	})
+
+	var result []string
+	for _, image := range f.FilterImages(list.Images) {
+		result = append(result, r.Rewrite(image))
+	}
+	return result
+}
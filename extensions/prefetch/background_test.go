@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestPrioritize(t *testing.T) {
+	var tests = map[string][]string{
+		"TestB": {"nginx:1.21", "docker.io/library/busybox"},
+		"TestA": {"alpine"},
+	}
+	list, err := prioritize([]string{"redis", "busybox", "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0", "nginx:1.21", "alpine"}, []string{"networkservicemesh/"}, tests)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0", "alpine", "busybox", "nginx:1.21", "redis"}, list)
+
+	_, err = prioritize(nil, []string{"("}, nil)
+	require.Error(t, err)
+}
+
+func TestSuite_TestImages(t *testing.T) {
+	var repoDir = writeExamples(t)
+	for dir, image := range map[string]string{"memif/nse": "memif-nse", "spire/spire": "spire-server", "kernel/nse": "kernel-nse"} {
+		var path = filepath.Join(repoDir, examplesDirName, filepath.FromSlash(dir))
+		require.NoError(t, os.MkdirAll(path, 0750))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(path, "kustomization.yaml"), []byte("resources:\n- pod.yaml\n"), 0600))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(path, "pod.yaml"),
+			[]byte("kind: Pod\nspec:\n  containers:\n  - image: "+image+"\n"), 0600))
+	}
+
+	f, err := images.NewFilter("", "image:kernel", "")
+	require.NoError(t, err)
+	r, err := images.NewRewriter([]string{"docker.io=mirror.io"}, nil)
+	require.NoError(t, err)
+
+	var s = &Suite{Dir: repoDir}
+	var tests = s.testImages(context.Background(), nil, f, r)
+	require.Len(t, tests, 3)
+	require.ElementsMatch(t, []string{"mirror.io/library/memif-nse:latest", "mirror.io/library/spire-server:latest"}, tests["Basic/TestMemif"])
+	require.Empty(t, tests["Basic/TestKernel"])
+	require.Equal(t, []string{"mirror.io/library/spire-server:latest"}, tests["TestSpire"])
+
+	require.Len(t, s.testImages(context.Background(), testSelector{regexp.MustCompile("TestSpire")}, f, r), 1)
+}
+
+func TestSuite_TestImages_SameName(t *testing.T) {
+	var repoDir = writeExamples(t)
+	for dir, content := range map[string]string{
+		"memif/nse/pod.yaml":                    "kind: Pod\nspec:\n  containers:\n  - image: memif-nse\n",
+		"features/README.md":                    "# Features\n\n## Includes\n\n- [Memif](./memif)\n",
+		"features/memif/README.md":              "# Memif\n\n## Run\n\n```bash\nkubectl apply -k ./nse\n```\n",
+		"features/memif/nse/pod.yaml":           "kind: Pod\nspec:\n  containers:\n  - image: features-nse\n",
+		"memif/nse/kustomization.yaml":          "resources:\n- pod.yaml\n",
+		"features/memif/nse/kustomization.yaml": "resources:\n- pod.yaml\n",
+	} {
+		var path = filepath.Join(repoDir, examplesDirName, filepath.FromSlash(dir))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+
+	r, err := images.NewRewriter(nil, nil)
+	require.NoError(t, err)
+
+	var s = &Suite{Dir: repoDir}
+	var b = &background{tests: s.testImages(context.Background(), nil, new(images.Filter), r)}
+
+	list, ok := b.testImages("TestRunBasicSuite/TestMemif", "TestMemif")
+	require.True(t, ok)
+	require.Equal(t, []string{"memif-nse"}, list)
+
+	list, ok = b.testImages("TestRunFeaturesSuite/TestMemif", "TestMemif")
+	require.True(t, ok)
+	require.Equal(t, []string{"features-nse"}, list)
+
+	list, ok = b.testImages("TestMemif", "TestMemif")
+	require.True(t, ok)
+	require.ElementsMatch(t, []string{"memif-nse", "features-nse"}, list)
+
+	_, ok = b.testImages("TestRunBasicSuite/TestUnknown", "TestUnknown")
+	require.False(t, ok)
+}
+
+func TestOrderGroups(t *testing.T) {
+	var plan = [][]string{{"redis", "nginx"}, {"alpine"}, {"busybox", "ghcr.io/networkservicemesh/cmd-nsc"}}
+	require.Equal(t, [][]string{{"busybox", "ghcr.io/networkservicemesh/cmd-nsc"}, {"alpine"}, {"redis", "nginx"}},
+		orderGroups(plan, []string{"ghcr.io/networkservicemesh/cmd-nsc", "alpine", "busybox", "nginx", "redis"}))
+}
+
+func TestPrefetcher_Background(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client = fake.NewSimpleClientset()
+	runController(ctx, t, client, corev1.PodStatus{
+		Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+	})
+
+	var finished = make(chan error, 1)
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Second * 5}
+	b, err := p.prefetchInBackground([][]string{{"alpine"}, {"nginx", "busybox"}}, 1, func(err error) { finished <- err })
+	require.NoError(t, err)
+	defer b.stop()
+
+	require.NoError(t, b.wait(ctx, imageMatcher([]string{"docker.io/library/nginx:latest"})))
+	require.NoError(t, b.wait(ctx, func(string) bool { return true }))
+	require.NoError(t, <-finished)
+}
+
+func TestPrefetcher_BackgroundStop(t *testing.T) {
+	controllerCtx, cancelController := context.WithCancel(context.Background())
+	defer cancelController()
+
+	var client = fake.NewSimpleClientset()
+	runController(controllerCtx, t, client, corev1.PodStatus{})
+
+	var finished = make(chan error, 1)
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Minute}
+	b, err := p.prefetchInBackground([][]string{{"alpine"}}, 1, func(err error) { finished <- err })
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	require.Error(t, b.wait(ctx, imageMatcher([]string{"alpine"})))
+	require.NoError(t, b.wait(ctx, imageMatcher([]string{"nginx"})))
+
+	b.stop()
+	require.Error(t, <-finished)
+	require.NoError(t, b.wait(context.Background(), imageMatcher([]string{"alpine"})))
+}
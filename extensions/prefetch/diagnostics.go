@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	statusPulled  = "pulled"
+	statusPending = "pending"
+
+	invalidImageNameReason = "InvalidImageName"
+)
+
+// imageStatus is the prefetch status of the image on the node.
+type imageStatus struct {
+	image   string
+	node    string
+	status  string
+	message string
+}
+
+func (s *imageStatus) isPulled() bool {
+	return s.status == statusPulled
+}
+
+// isFailed returns true if the image has an error reason, e.g. ErrImagePull, or a warning event. Init containers
+// are pulled one after another, so images queued after the failed one are pending, not failed.
+func (s *imageStatus) isFailed() bool {
+	return !s.isPulled() && (s.status != statusPending || s.message != "")
+}
+
+// diagnose returns statuses of the images of the pods, messages are taken from the container statuses
+// or, if they are empty, from the pod events.
+func (p *prefetcher) diagnose(ctx context.Context, pods map[string]*corev1.Pod) []*imageStatus {
+	var events = p.podEvents(ctx)
+
+	var result []*imageStatus
+	for _, pod := range pods {
+		var statuses = map[string]*corev1.ContainerStatus{}
+		for _, list := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+			for i := range list {
+				statuses[list[i].Name] = &list[i]
+			}
+		}
+		var containers = append(append([]corev1.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for i := range containers {
+			var container = &containers[i]
+			if container.Name == returnContainerName || container.Name == pauseContainerName {
+				continue
+			}
+			var s = containerImageStatus(container.Image, statuses[container.Name])
+			s.node = pod.Spec.NodeName
+			if s.message == "" && !s.isPulled() {
+				s.message = eventMessage(events[pod.Name], container.Image)
+			}
+			result = append(result, s)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].image != result[j].image {
+			return result[i].image < result[j].image
+		}
+		return result[i].node < result[j].node
+	})
+	return result
+}
+
+func containerImageStatus(image string, status *corev1.ContainerStatus) *imageStatus {
+	var result = &imageStatus{image: image, status: statusPending}
+	switch {
+	case status == nil:
+	case status.State.Running != nil || status.State.Terminated != nil:
+		result.status = statusPulled
+	case status.State.Waiting != nil && status.State.Waiting.Reason != "" &&
+		status.State.Waiting.Reason != "PodInitializing" && status.State.Waiting.Reason != "ContainerCreating":
+		result.status = status.State.Waiting.Reason
+		result.message = status.State.Waiting.Message
+	}
+	return result
+}
+
+// eventMessage returns the message of the last warning event about the image.
+func eventMessage(events []corev1.Event, image string) string {
+	var result string
+	for i := range events {
+		if events[i].Type == corev1.EventTypeWarning && strings.Contains(events[i].Message, `"`+image+`"`) {
+			result = events[i].Message
+		}
+	}
+	return result
+}
+
+// formatStatuses returns the image × node table of the statuses.
+func formatStatuses(statuses []*imageStatus) string {
+	var sb strings.Builder
+	var w = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "IMAGE\tNODE\tSTATUS\tMESSAGE")
+	for _, s := range statuses {
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.image, s.node, s.status, s.message)
+	}
+	_ = w.Flush()
+	return sb.String()
+}
+
+// brokenImages returns sorted unique images failed at least on one node and images that are not pulled only because
+// they wait for the previous images.
+func brokenImages(statuses []*imageStatus) (failed, pending []string) {
+	var isFailed = map[string]bool{}
+	var isPending = map[string]bool{}
+	for _, s := range statuses {
+		switch {
+		case s.isFailed():
+			isFailed[s.image] = true
+		case !s.isPulled():
+			isPending[s.image] = true
+		}
+	}
+	for image := range isFailed {
+		failed = append(failed, image)
+	}
+	for image := range isPending {
+		if !isFailed[image] {
+			pending = append(pending, image)
+		}
+	}
+	sort.Strings(failed)
+	sort.Strings(pending)
+	return failed, pending
+}
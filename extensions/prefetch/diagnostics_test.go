@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiagnose(t *testing.T) {
+	var pod = func(node string, statuses ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "prefetch-0-" + node, Namespace: testNamespace},
+			Spec:       daemonSet(testNamespace, 0, []string{"alpine", "ghcr.io/org/missing"}, &podOptions{}).Spec.Template.Spec,
+			Status:     corev1.PodStatus{InitContainerStatuses: statuses},
+		}
+	}
+	var pulled = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}
+	var pods = map[string]*corev1.Pod{
+		"a": pod("a",
+			corev1.ContainerStatus{Name: "image-0", State: pulled},
+			corev1.ContainerStatus{Name: "image-1", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason:  "ErrImagePull",
+				Message: "manifest unknown",
+			}}},
+		),
+		"b": pod("b", corev1.ContainerStatus{Name: "image-0", State: pulled}),
+	}
+	pods["a"].Spec.NodeName, pods["b"].Spec.NodeName = "node-a", "node-b"
+
+	var p = &prefetcher{client: fake.NewSimpleClientset(&corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "failed", Namespace: testNamespace},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "prefetch-0-b"},
+		Type:           corev1.EventTypeWarning,
+		Message:        `Failed to pull image "ghcr.io/org/missing": unauthorized`,
+	}), namespace: testNamespace}
+	var statuses = p.diagnose(context.Background(), pods)
+
+	failed, pending := brokenImages(statuses)
+	require.Equal(t, []string{"ghcr.io/org/missing"}, failed)
+	require.Empty(t, pending)
+	require.Equal(t, ""+
+		"IMAGE                NODE    STATUS        MESSAGE\n"+
+		"alpine               node-a  pulled        \n"+
+		"alpine               node-b  pulled        \n"+
+		"ghcr.io/org/missing  node-a  ErrImagePull  manifest unknown\n"+
+		"ghcr.io/org/missing  node-b  pending       Failed to pull image \"ghcr.io/org/missing\": unauthorized\n",
+		formatStatuses(statuses))
+}
+
+func TestBrokenImages_Queued(t *testing.T) {
+	var containers = daemonSet(testNamespace, 0, []string{"alpine", "ghcr.io/org/missing", "nginx"}, &podOptions{}).Spec.Template.Spec.InitContainers
+	var pod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prefetch-0-a", Namespace: testNamespace},
+		Spec:       corev1.PodSpec{NodeName: "node-a", InitContainers: containers},
+		Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{
+			{Name: "image-0", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}},
+			{Name: "image-1", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+			{Name: "image-2", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+		}},
+	}
+
+	var p = &prefetcher{client: fake.NewSimpleClientset(), namespace: testNamespace}
+	failed, pending := brokenImages(p.diagnose(context.Background(), map[string]*corev1.Pod{"a": pod}))
+	require.Equal(t, []string{"ghcr.io/org/missing"}, failed)
+	require.Equal(t, []string{"nginx"}, pending)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const (
+	manifestsFileName = "prefetch.yaml"
+	planFileName      = "prefetch-plan.txt"
+)
+
+// dryRun writes the manifests of the prefetch and the plan report to the artifacts directory of the suite.
+// The cluster is not used, so images present on the nodes are not skipped and Jobs of the job mode are not bound
+// to nodes.
+func (s *Suite) dryRun(config *Config, list []string, sources map[string][]string) {
+	if config.Background {
+		list, _ = s.orderImages(config, list)
+	}
+	var r = newRun(config.RunID)
+	var p = &prefetcher{
+		namespace: r.namespace(),
+		labels:    r.labels(),
+		mode:      config.Mode,
+		pod: podOptions{
+			tolerateAll:  config.TolerateAll,
+			nodeSelector: config.NodeSelector,
+			pullSecrets:  pullSecretNames(config.PullSecrets),
+			returnImage:  config.ReturnImage,
+			pauseImage:   config.PauseImage,
+		},
+	}
+	var plan = s.plan(config, list)
+
+	var dir = filepath.Join(config.ArtifactsDir, s.T().Name())
+	require.NoError(s.T(), os.MkdirAll(dir, os.ModePerm))
+
+	var manifests strings.Builder
+	require.NoError(s.T(), p.render(&manifests, plan))
+	require.NoError(s.T(), ioutil.WriteFile(filepath.Join(dir, manifestsFileName), []byte(manifests.String()), os.ModePerm))
+	require.NoError(s.T(), ioutil.WriteFile(filepath.Join(dir, planFileName), []byte(p.formatPlan(plan, sources)), os.ModePerm))
+
+	logrus.Infof("Dry run: prefetch manifests are written to %v, the plan is written to %v",
+		filepath.Join(dir, manifestsFileName), filepath.Join(dir, planFileName))
+}
+
+// render writes the namespace and the workloads of the plan as a multi-document YAML.
+func (p *prefetcher) render(w io.Writer, plan [][]string) error {
+	var objects = []interface{}{&corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: p.namespace, Labels: p.labels},
+	}}
+	for i, group := range plan {
+		if p.mode == jobMode {
+			var j = job(p.namespace, i, 0, "", group, &p.pod)
+			j.TypeMeta = metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"}
+			objects = append(objects, j)
+			continue
+		}
+		var ds = daemonSet(p.namespace, i, group, &p.pod)
+		ds.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"}
+		objects = append(objects, ds)
+	}
+
+	for _, object := range objects {
+		b, err := yaml.Marshal(object)
+		if err != nil {
+			return errors.Wrap(err, "failed to render prefetch manifests")
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", b); err != nil {
+			return errors.Wrap(err, "failed to render prefetch manifests")
+		}
+	}
+	return nil
+}
+
+// formatPlan returns a table of the images with the workload pulling them and their source files.
+func (p *prefetcher) formatPlan(plan [][]string, sources map[string][]string) string {
+	var sb strings.Builder
+	var w = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "IMAGE\tWORKLOAD\tSOURCES")
+	for i, group := range plan {
+		var workload = "daemonset/" + daemonSet(p.namespace, i, nil, &p.pod).Name
+		if p.mode == jobMode {
+			workload = "job/" + job(p.namespace, i, 0, "", nil, &p.pod).Name
+		}
+		for _, image := range group {
+			var files = sources[imageKeys(image)[0]]
+			if len(files) == 0 {
+				files = []string{"-"}
+			}
+			_, _ = fmt.Fprintf(w, "%v\t%v\t%v\n", image, workload, strings.Join(files, ", "))
+		}
+	}
+	_ = w.Flush()
+	return sb.String()
+}
+
+// sourceFiles returns the sorted source files of the filtered and rewritten images by the normalized image.
+func sourceFiles(files map[string][]string, f *images.Filter, r *images.Rewriter) map[string][]string {
+	var visited = map[string]bool{}
+	var result = map[string][]string{}
+	for file, list := range files {
+		for _, image := range list {
+			if !f.MatchImage(image) {
+				continue
+			}
+			var key = imageKeys(r.Rewrite(image))[0]
+			if !visited[key+" "+file] {
+				visited[key+" "+file] = true
+				result[key] = append(result[key], strings.TrimPrefix(file, "file://"))
+			}
+		}
+	}
+	for _, list := range result {
+		sort.Strings(list)
+	}
+	return result
+}
+
+// pullSecretNames returns names of the pull secrets referenced by name or by namespace/name.
+func pullSecretNames(secrets []string) []string {
+	var result []string
+	for _, secret := range secrets {
+		result = append(result, secret[strings.Index(secret, "/")+1:])
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestSuite_DryRun(t *testing.T) {
+	var config = &Config{
+		ImagesPerDaemonset: 2,
+		Mode:               daemonSetMode,
+		RunID:              "dry",
+		ReturnImage:        "return",
+		PauseImage:         "pause",
+		PullSecrets:        []string{"ci/ghcr"},
+		ArtifactsDir:       t.TempDir(),
+	}
+	var s = new(Suite)
+	s.SetT(t)
+	s.dryRun(config, []string{"alpine", "nginx:1.21", "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, map[string][]string{
+		"docker.io/library/alpine:latest": {"/repo/apps/a.yaml", "/repo/apps/b.yaml"},
+	})
+
+	var dir = filepath.Join(config.ArtifactsDir, t.Name())
+	manifests, err := ioutil.ReadFile(filepath.Join(dir, manifestsFileName))
+	require.NoError(t, err)
+	var docs = strings.Split(string(manifests), "---\n")[1:]
+	require.Len(t, docs, 3)
+	require.Contains(t, docs[0], "kind: Namespace")
+	require.Regexp(t, "name: prefetch-dry-[0-9a-f]{8}", docs[0])
+
+	var ds appsv1.DaemonSet
+	require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &ds))
+	require.Equal(t, "DaemonSet", ds.Kind)
+	require.Regexp(t, "^prefetch-dry-[0-9a-f]{8}$", ds.Namespace)
+	require.Equal(t, "ghcr", ds.Spec.Template.Spec.ImagePullSecrets[0].Name)
+	require.Equal(t, "alpine", ds.Spec.Template.Spec.InitContainers[1].Image)
+
+	plan, err := ioutil.ReadFile(filepath.Join(dir, planFileName))
+	require.NoError(t, err)
+	require.Equal(t, "IMAGE                                      WORKLOAD              SOURCES\n"+
+		"alpine                                     daemonset/prefetch-0  /repo/apps/a.yaml, /repo/apps/b.yaml\n"+
+		"nginx:1.21                                 daemonset/prefetch-0  -\n"+
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0  daemonset/prefetch-1  -\n", string(plan))
+}
+
+func TestSourceFiles(t *testing.T) {
+	f, err := images.NewFilter("", "image:redis", "")
+	require.NoError(t, err)
+	r, err := images.NewRewriter([]string{"docker.io=mirror.io"}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]string{
+		"mirror.io/library/alpine:latest": {"/repo/a.yaml", "/repo/b.yaml"},
+		"ghcr.io/org/nsc:v1":              {"https://raw.githubusercontent.com/org/repo/main/c.yaml"},
+	}, sourceFiles(map[string][]string{
+		"file:///repo/b.yaml": {"alpine", "docker.io/library/alpine", "redis"},
+		"file:///repo/a.yaml": {"alpine:latest"},
+		"https://raw.githubusercontent.com/org/repo/main/c.yaml": {"ghcr.io/org/nsc:v1"},
+	}, f, r))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// commitSHAPattern matches full commit SHAs in paths and query values of github URLs.
+var commitSHAPattern = regexp.MustCompile(`[/=][0-9a-f]{40}([/?&]|$)`)
+
+// cacheEntry is the cached response. Metadata is stored in the <key>.json file, content in the <key>.data file.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Immutable    bool   `json:"immutable,omitempty"`
+	body         []byte
+}
+
+// cache is an on-disk cache of remote content keyed by URL. nil cache is disabled.
+type cache struct {
+	dir string
+}
+
+func isImmutable(rawurl string) bool {
+	return commitSHAPattern.MatchString(rawurl)
+}
+
+func (c *cache) path(rawurl string) string {
+	var key = sha256.Sum256([]byte(rawurl))
+	return filepath.Join(c.dir, hex.EncodeToString(key[:]))
+}
+
+func (c *cache) load(rawurl string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	var p = c.path(rawurl)
+
+	meta, err := ioutil.ReadFile(filepath.Clean(p + ".json"))
+	if err != nil {
+		return nil
+	}
+	var entry = new(cacheEntry)
+	if err = json.Unmarshal(meta, entry); err != nil || entry.URL != rawurl {
+		return nil
+	}
+	if entry.body, err = ioutil.ReadFile(filepath.Clean(p + ".data")); err != nil {
+		return nil
+	}
+	return entry
+}
+
+func (c *cache) store(entry *cacheEntry) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return errors.Wrapf(err, "failed to create cache dir %v", c.dir)
+	}
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode cache entry of %v", entry.URL)
+	}
+	var p = c.path(entry.URL)
+	if err = writeFileAtomic(p+".data", entry.body); err != nil {
+		return err
+	}
+	return writeFileAtomic(p+".json", meta)
+}
+
+// writeFileAtomic writes the file via rename, so concurrent test binaries never read partially written files.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temp file for %v", name)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "failed to write %v", tmp.Name())
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %v", tmp.Name())
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), name), "failed to rename %v", tmp.Name())
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultExclude excludes SR-IOV and VFIO sources, their images can't be pulled on most of the clusters.
+const DefaultExclude = "path:(.*-sriov)|(.*-vfio)"
+
+const (
+	pathTarget  = "path:"
+	imageTarget = "image:"
+)
+
+// Filter is a chain of include and exclude rules for source paths and images.
+// A path or an image is prefetched if it matches any include rule of its target, or there are no such rules,
+// and it doesn't match any exclude rule of its target.
+//
+// Rule is a regex prefixed with the target: `path:` or `image:`. Include rules target images by default,
+// exclude rules target both paths and images by default. Images are matched in the raw and in the normalized form.
+type Filter struct {
+	rules []*filterRule
+}
+
+type filterRule struct {
+	exclude bool
+	path    bool
+	image   bool
+	regexp  *regexp.Regexp
+}
+
+// NewFilter creates filter from the include and exclude rules and the rules of the filter file.
+func NewFilter(include, exclude, file string) (*Filter, error) {
+	var f = new(Filter)
+	if include != "" {
+		if err := f.add(false, include); err != nil {
+			return nil, err
+		}
+	}
+	if exclude != "" {
+		if err := f.add(true, exclude); err != nil {
+			return nil, err
+		}
+	}
+	if file != "" {
+		if err := f.load(file); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) add(exclude bool, rule string) error {
+	var r = &filterRule{exclude: exclude, path: exclude, image: true}
+	switch {
+	case strings.HasPrefix(rule, pathTarget):
+		rule, r.path, r.image = strings.TrimPrefix(rule, pathTarget), true, false
+	case strings.HasPrefix(rule, imageTarget):
+		rule, r.path, r.image = strings.TrimPrefix(rule, imageTarget), false, true
+	}
+
+	var err error
+	if r.regexp, err = regexp.Compile(rule); err != nil {
+		return errors.Wrapf(err, "invalid filter rule %q", rule)
+	}
+	f.rules = append(f.rules, r)
+	return nil
+}
+
+// load reads rules from the file. Each line is `include <rule>` or `exclude <rule>`, empty lines and lines starting
+// with # are skipped.
+func (f *Filter) load(file string) error {
+	r, err := os.Open(file) // #nosec
+	if err != nil {
+		return errors.Wrap(err, "failed to open filter file")
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var scanner = bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var fields = strings.SplitN(text, " ", 2)
+		if len(fields) != 2 || fields[0] != "include" && fields[0] != "exclude" {
+			return errors.Errorf("%v:%v: expected include or exclude rule, got %q", file, line, text)
+		}
+		if err := f.add(fields[0] == "exclude", strings.TrimSpace(fields[1])); err != nil {
+			return errors.Wrapf(err, "%v:%v", file, line)
+		}
+	}
+	return errors.Wrapf(scanner.Err(), "failed to read filter file %v", file)
+}
+
+// PathRules returns the path rules in the order of the filter file, e.g. `exclude path:-sriov`.
+func (f *Filter) PathRules() []string {
+	var result []string
+	for _, r := range f.rules {
+		if !r.path {
+			continue
+		}
+		var action = "include"
+		if r.exclude {
+			action = "exclude"
+		}
+		result = append(result, action+" "+pathTarget+r.regexp.String())
+	}
+	return result
+}
+
+// MatchPath returns true if images of the source path should be prefetched.
+func (f *Filter) MatchPath(path string) bool {
+	return f.match([]string{path}, func(r *filterRule) bool { return r.path })
+}
+
+// MatchImage returns true if the image should be prefetched.
+func (f *Filter) MatchImage(image string) bool {
+	var names = []string{image}
+	if ref, err := ParseReference(image); err == nil && ref.String() != image {
+		names = append(names, ref.String())
+	}
+	return f.match(names, func(r *filterRule) bool { return r.image })
+}
+
+func (f *Filter) match(names []string, target func(r *filterRule) bool) bool {
+	var included, hasIncludes bool
+	for _, r := range f.rules {
+		if !target(r) {
+			continue
+		}
+		var matched = matchAny(r.regexp, names)
+		if r.exclude && matched {
+			return false
+		}
+		if !r.exclude {
+			hasIncludes = true
+			included = included || matched
+		}
+	}
+	return included || !hasIncludes
+}
+
+func matchAny(re *regexp.Regexp, names []string) bool {
+	for _, name := range names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterImages returns images that should be prefetched and logs the skipped ones.
+func (f *Filter) FilterImages(list []string) []string {
+	var result = make([]string, 0, len(list))
+	for _, image := range list {
+		if !f.MatchImage(image) {
+			logrus.Infof("Image %v is not prefetched: filtered out", image)
+			continue
+		}
+		result = append(result, image)
+	}
+	return result
+}
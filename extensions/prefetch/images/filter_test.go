@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestFilter(t *testing.T) {
+	var file = filepath.Join(t.TempDir(), "filter")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+# nsm images and alpine only
+include ^ghcr.io/networkservicemesh/
+include ^docker.io/library/alpine:
+exclude image:cmd-nse-icmp
+`), 0600))
+
+	f, err := images.NewFilter("", "path:(.*-sriov)|(.*-vfio)", file)
+	require.NoError(t, err)
+
+	require.True(t, f.MatchPath("apps/nsc-kernel/nsc.yaml"))
+	require.False(t, f.MatchPath("apps/forwarder-sriov/forwarder.yaml"))
+	require.Equal(t, []string{"exclude path:(.*-sriov)|(.*-vfio)"}, f.PathRules())
+	require.Equal(t, []string{
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+		"alpine:3.15",
+	}, f.FilterImages([]string{
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+		"ghcr.io/networkservicemesh/cmd-nse-icmp-responder:v1.0.0",
+		"alpine:3.15",
+		"nginx",
+	}))
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	_, err := images.NewFilter("(", "", "")
+	require.Error(t, err)
+
+	var file = filepath.Join(t.TempDir(), "filter")
+	require.NoError(t, ioutil.WriteFile(file, []byte("skip nginx\n"), 0600))
+	_, err = images.NewFilter("", "", file)
+	require.Error(t, err)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Sources of the deployments repository with images of the applications used by all examples.
+const (
+	ExternalImagesPath = "external-images.yaml"
+	AppsPath           = "apps"
+)
+
+// GithubSourcesURLs returns URLs of the paths in the github repository of the ref. Paths ending with .yaml are read
+// as raw files, other paths are listed as directories by the contents API.
+func GithubSourcesURLs(repository, ref string, paths ...string) []string {
+	var result []string
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".yaml") {
+			result = append(result, fmt.Sprintf("%v/%v/%v/%v", defaultGithubRaw, repository, ref, path))
+		} else {
+			result = append(result, fmt.Sprintf("%v/repos/%v/contents/%v?ref=%v", defaultGithubAPI, repository, path, ref))
+		}
+	}
+	return result
+}
+
+// githubLocation is a parsed github contents API URL: {api}/repos/{owner}/{repo}/contents/{path}?ref={ref}.
+type githubLocation struct {
+	owner, repo, path, ref string
+}
+
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Tree struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	} `json:"commit"`
+}
+
+type githubTree struct {
+	Truncated bool `json:"truncated"`
+	Tree      []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+	} `json:"tree"`
+}
+
+type githubSource struct {
+	*httpSource
+	refsMu sync.Mutex
+	refs   map[string]*githubCommit
+}
+
+// NewGithubSource returns the Source for github contents API locations: https://api.github.com/repos/...
+// Other locations are read as remote files.
+func NewGithubSource(opts ...Option) Source {
+	return &githubSource{httpSource: &httpSource{options: newOptions(opts)}}
+}
+
+// List resolves the ref of the contents URL once and lists matching files of the recursive git tree
+// with a single request. Falls back to the contents API if the tree is truncated.
+func (s *githubSource) List(ctx context.Context, rawurl string, match func(string) bool) ([]string, error) {
+	if !strings.HasPrefix(rawurl, s.githubAPI+"/repos/") {
+		return []string{rawurl}, nil
+	}
+
+	loc, err := s.parseGithubLocation(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := s.resolveGithubRef(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := s.get(ctx, fmt.Sprintf("%v/repos/%v/%v/git/trees/%v?recursive=1", s.githubAPI, loc.owner, loc.repo, commit.Commit.Tree.SHA))
+	if err != nil {
+		return nil, err
+	}
+	var tree githubTree
+	if err = json.Unmarshal(b, &tree); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode github tree of %v", rawurl)
+	}
+	if tree.Truncated {
+		return s.listContents(ctx, rawurl, match)
+	}
+
+	var result []string
+	for _, entry := range tree.Tree {
+		if entry.Type != "blob" {
+			continue
+		}
+		if loc.path != "" && entry.Path != loc.path && !strings.HasPrefix(entry.Path, loc.path+"/") {
+			continue
+		}
+		if match(entry.Path) {
+			result = append(result, fmt.Sprintf("%v/%v/%v/%v/%v", s.githubRaw, loc.owner, loc.repo, commit.SHA, entry.Path))
+		}
+	}
+	if len(result) == 0 && !tree.hasPath(loc.path) {
+		return nil, errors.Errorf("%v is not found in %v/%v@%v", loc.path, loc.owner, loc.repo, loc.ref)
+	}
+	return result, nil
+}
+
+func (t *githubTree) hasPath(p string) bool {
+	if p == "" {
+		return true
+	}
+	for _, entry := range t.Tree {
+		if entry.Path == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *githubSource) parseGithubLocation(rawurl string) (*githubLocation, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", rawurl)
+	}
+	var p = strings.SplitN(strings.TrimPrefix(rawurl, s.githubAPI+"/repos/"), "?", 2)[0]
+	var segments = strings.SplitN(p, "/", 4)
+	if len(segments) < 3 || segments[2] != "contents" {
+		return nil, errors.Errorf("%v is not a github contents URL", rawurl)
+	}
+	var result = &githubLocation{
+		owner: segments[0],
+		repo:  segments[1],
+		ref:   u.Query().Get("ref"),
+	}
+	if len(segments) == 4 {
+		result.path = strings.Trim(segments[3], "/")
+	}
+	if result.ref == "" {
+		result.ref = "HEAD"
+	}
+	return result, nil
+}
+
+// resolveGithubRef resolves the ref into the commit. Resolved refs are shared by all sources.
+func (s *githubSource) resolveGithubRef(ctx context.Context, loc *githubLocation) (*githubCommit, error) {
+	var key = loc.owner + "/" + loc.repo + "@" + loc.ref
+
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+
+	if commit, ok := s.refs[key]; ok {
+		return commit, nil
+	}
+
+	b, err := s.get(ctx, fmt.Sprintf("%v/repos/%v/%v/commits/%v", s.githubAPI, loc.owner, loc.repo, loc.ref))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %v", key)
+	}
+	var commit = new(githubCommit)
+	if err = json.Unmarshal(b, commit); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode github commit %v", key)
+	}
+
+	if s.refs == nil {
+		s.refs = map[string]*githubCommit{}
+	}
+	s.refs[key] = commit
+	return commit, nil
+}
+
+// listContents lists files via the contents API walking directories one by one.
+func (s *githubSource) listContents(ctx context.Context, rawurl string, match func(string) bool) ([]string, error) {
+	b, err := s.get(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []map[string]interface{}
+	var result []string
+
+	if err = json.Unmarshal(b, &objects); err != nil {
+		var object map[string]interface{}
+		if err = json.Unmarshal(b, &object); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode github contents %v", rawurl)
+		}
+		objects = append(objects, object)
+	}
+
+	var errs Errors
+	for _, obj := range objects {
+		if _, ok := obj["path"]; !ok {
+			continue
+		}
+		if _, ok := obj["type"]; !ok {
+			continue
+		}
+		var p = obj["path"].(string)
+
+		if obj["type"] == "file" {
+			if match(p) {
+				result = append(result, obj["download_url"].(string))
+			}
+		}
+
+		if obj["type"] == "dir" {
+			var nextContentsURL = apiContentsURL(rawurl, p)
+			list, err := s.listContents(ctx, nextContentsURL, match)
+			result = append(result, list...)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+func apiContentsURL(contentsURL, newPath string) string {
+	var u, _ = url.Parse(contentsURL)
+	var segments = strings.Split(u.Path, string(filepath.Separator))
+
+	segments = append(segments[:5], newPath)
+	u.Path = strings.Join(segments, string(filepath.Separator))
+
+	return u.String()
+}
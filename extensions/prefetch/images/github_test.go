@@ -0,0 +1,253 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const nscPod = `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: nsc
+spec:
+  containers:
+    - name: nsc
+      image: ghcr.io/networkservicemesh/cmd-nsc:v1.0.0
+`
+
+const (
+	commitSHA = "0cbf84ac727babe089ca541c5ed1a80db862aaab"
+	treeSHA   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+)
+
+type githubObject struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// githubServer emulates the github commits, git trees, contents and raw content APIs for the org/repo repository.
+type githubServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  map[string]int
+	truncated bool
+	// handle can reply instead of the emulated API, returns true if the request is handled.
+	handle func(w http.ResponseWriter, r *http.Request, attempt int) bool
+}
+
+func newGithubServer(t *testing.T) *githubServer {
+	var s = &githubServer{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *githubServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	var attempt = s.requests[r.URL.Path]
+	s.mu.Unlock()
+
+	if s.handle != nil && s.handle(w, r, attempt) {
+		return
+	}
+
+	var etag = `"` + r.URL.Path + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	var objects interface{}
+	switch r.URL.Path {
+	case "/repos/org/repo/commits/v1.0.0":
+		objects = map[string]interface{}{
+			"sha":    commitSHA,
+			"commit": map[string]interface{}{"tree": map[string]string{"sha": treeSHA}},
+		}
+	case "/repos/org/repo/git/trees/" + treeSHA:
+		objects = map[string]interface{}{
+			"truncated": s.truncated,
+			"tree": []map[string]string{
+				{"path": "apps", "type": "tree"},
+				{"path": "apps/README.md", "type": "blob"},
+				{"path": "apps/nsc", "type": "tree"},
+				{"path": "apps/nsc/nsc.yaml", "type": "blob"},
+				{"path": "external-images.yaml", "type": "blob"},
+			},
+		}
+	case "/repos/org/repo/contents/apps":
+		objects = []githubObject{
+			{Name: "nsc", Path: "apps/nsc", Type: "dir"},
+			{Name: "README.md", Path: "apps/README.md", Type: "file", DownloadURL: s.URL + "/raw/apps/README.md"},
+		}
+	case "/repos/org/repo/contents/apps/nsc":
+		objects = []githubObject{
+			{Name: "nsc.yaml", Path: "apps/nsc/nsc.yaml", Type: "file", DownloadURL: s.URL + "/raw/apps/nsc/nsc.yaml"},
+		}
+	case "/raw/apps/nsc/nsc.yaml", "/raw/org/repo/" + commitSHA + "/apps/nsc/nsc.yaml":
+		_, _ = w.Write([]byte(nscPod))
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(objects)
+}
+
+func (s *githubServer) reterive(opts ...images.Option) (*images.ImageList, error) {
+	opts = append([]images.Option{
+		images.WithGithubAPI(s.URL),
+		images.WithGithubRaw(s.URL + "/raw"),
+		images.WithRetry(3, time.Millisecond),
+	}, opts...)
+	return images.ReteriveListContext(context.Background(), []string{s.URL + "/repos/org/repo/contents/apps?ref=v1.0.0"}, yamlFileMatch, opts...)
+}
+
+func TestReteriveListContext_GithubToken(t *testing.T) {
+	var s = newGithubServer(t)
+	s.handle = func(w http.ResponseWriter, r *http.Request, _ int) bool {
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+
+	_, err := s.reterive()
+	require.Error(t, err)
+
+	list, err := s.reterive(images.WithGithubToken("secret"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+}
+
+func TestReteriveListContext_GithubRetries(t *testing.T) {
+	var s = newGithubServer(t)
+	s.handle = func(w http.ResponseWriter, r *http.Request, attempt int) bool {
+		switch attempt {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			return false
+		}
+		return true
+	}
+
+	list, err := s.reterive()
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+	require.Equal(t, 3, s.requests["/repos/org/repo/git/trees/"+treeSHA])
+}
+
+func TestReteriveListContext_GithubRateLimitExceeded(t *testing.T) {
+	var s = newGithubServer(t)
+	s.handle = func(w http.ResponseWriter, r *http.Request, _ int) bool {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	list, err := s.reterive()
+	require.Error(t, err)
+	require.Empty(t, list.Images)
+	require.Equal(t, 1, s.requests["/repos/org/repo/commits/v1.0.0"])
+}
+
+func TestReteriveListContext_GithubNotFound(t *testing.T) {
+	var s = newGithubServer(t)
+
+	_, err := images.ReteriveListContext(context.Background(),
+		[]string{fmt.Sprintf("%v/repos/org/repo/contents/missing?ref=v1.0.0", s.URL)},
+		yamlFileMatch,
+		images.WithGithubAPI(s.URL),
+		images.WithGithubRaw(s.URL+"/raw"),
+	)
+	require.Error(t, err)
+	require.Equal(t, 1, s.requests["/repos/org/repo/git/trees/"+treeSHA])
+}
+
+func TestReteriveListContext_GithubTree(t *testing.T) {
+	var s = newGithubServer(t)
+
+	list, err := s.reterive()
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+	require.Equal(t, 1, s.requests["/repos/org/repo/commits/v1.0.0"])
+	require.Equal(t, 1, s.requests["/repos/org/repo/git/trees/"+treeSHA])
+	require.Zero(t, s.requests["/repos/org/repo/contents/apps"])
+	require.Equal(t, map[string][]string{
+		s.URL + "/raw/org/repo/" + commitSHA + "/apps/nsc/nsc.yaml": {"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"},
+	}, list.Files)
+}
+
+func TestReteriveListContext_GithubTruncatedTree(t *testing.T) {
+	var s = newGithubServer(t)
+	s.truncated = true
+
+	list, err := s.reterive()
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+	require.Equal(t, 1, s.requests["/repos/org/repo/contents/apps"])
+	require.Equal(t, 1, s.requests["/repos/org/repo/contents/apps/nsc"])
+}
+
+func TestReteriveListContext_GithubCache(t *testing.T) {
+	var s = newGithubServer(t)
+	var cacheDir = t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		list, err := s.reterive(images.WithCacheDir(cacheDir))
+		require.NoError(t, err)
+		require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+	}
+	// The ref is revalidated, the tree and the file of the resolved commit are immutable
+	require.Equal(t, 2, s.requests["/repos/org/repo/commits/v1.0.0"])
+	require.Equal(t, 1, s.requests["/repos/org/repo/git/trees/"+treeSHA])
+	require.Equal(t, 1, s.requests["/raw/org/repo/"+commitSHA+"/apps/nsc/nsc.yaml"])
+
+	s.handle = func(w http.ResponseWriter, r *http.Request, _ int) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	list, err := s.reterive(images.WithCacheDir(cacheDir))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, list.Images)
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-RateLimit-Reset"
+	retryAfterHeader         = "Retry-After"
+)
+
+// rateLimit remembers when the github rate limit has been exhausted and when it resets.
+type rateLimit struct {
+	mu    sync.Mutex
+	reset time.Time
+}
+
+func (l *rateLimit) update(resp *http.Response) {
+	if resp.Header.Get(rateLimitRemainingHeader) != "0" {
+		return
+	}
+	var reset = parseResetTime(resp.Header)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reset = reset
+}
+
+func (l *rateLimit) wait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Until(l.reset)
+}
+
+type httpSource struct {
+	*options
+	rateLimit rateLimit
+}
+
+func (s *httpSource) cache() *cache {
+	if s.cacheDir == "" {
+		return nil
+	}
+	return &cache{dir: s.cacheDir}
+}
+
+// NewHTTPSource returns the Source for remote files: http://... and https://...
+// Locations are not listed, each location is a single file.
+func NewHTTPSource(opts ...Option) Source {
+	return &httpSource{options: newOptions(opts)}
+}
+
+func (s *httpSource) List(_ context.Context, location string, _ func(string) bool) ([]string, error) {
+	return []string{location}, nil
+}
+
+func (s *httpSource) Read(ctx context.Context, location string) ([]byte, error) {
+	return s.get(ctx, location)
+}
+
+// get reads the remote content. Requests failed with 5xx or github rate limit errors are retried with exponential backoff.
+// If the cache is enabled, cached content is revalidated and used if the server is not reachable.
+func (s *httpSource) get(ctx context.Context, rawurl string) ([]byte, error) {
+	var cached = s.cache().load(rawurl)
+	if cached != nil && cached.Immutable {
+		return cached.body, nil
+	}
+
+	entry, err := s.fetch(ctx, rawurl, cached)
+	if err != nil {
+		if _, ok := errors.Cause(err).(*statusError); !ok && cached != nil {
+			return cached.body, nil
+		}
+		return nil, err
+	}
+	if entry != cached {
+		_ = s.cache().store(entry)
+	}
+	return entry.body, nil
+}
+
+func (s *httpSource) fetch(ctx context.Context, rawurl string, cached *cacheEntry) (*cacheEntry, error) {
+	var backoff = s.retryBackoff
+	for attempt := 0; ; attempt++ {
+		if wait := s.rateLimit.wait(); wait > 0 && s.isGithub(rawurl) {
+			if wait > s.maxWait {
+				return nil, errors.Errorf("failed to get %v: github rate limit exceeded, resets in %v", rawurl, wait.Round(time.Second))
+			}
+			if err := s.sleep(ctx, wait); err != nil {
+				return nil, errors.Wrapf(err, "failed to get %v", rawurl)
+			}
+		}
+
+		entry, retryAfter, err := s.do(ctx, rawurl, cached)
+		if err == nil {
+			return entry, nil
+		}
+		if retryAfter < 0 || attempt >= s.retries {
+			return nil, err
+		}
+
+		var delay = backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if delay > s.maxWait {
+			return nil, errors.Wrapf(err, "retry delay %v exceeds the limit", delay.Round(time.Second))
+		}
+		if err := s.sleep(ctx, delay); err != nil {
+			return nil, errors.Wrapf(err, "failed to get %v", rawurl)
+		}
+		backoff *= 2
+	}
+}
+
+// do makes a single request, conditional if the cached entry is known. Returns the cached entry if it is not modified.
+// Returns retryAfter < 0 if the request should not be retried and retryAfter > 0 if the server asked to wait.
+func (s *httpSource) do(ctx context.Context, rawurl string, cached *cacheEntry) (entry *cacheEntry, retryAfter time.Duration, err error) {
+	req, err := s.newRequest(ctx, rawurl, cached)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, -1, errors.Wrapf(err, "failed to get %v", rawurl)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if s.isGithub(rawurl) {
+		s.rateLimit.update(resp)
+	}
+
+	return handleResponse(rawurl, resp, cached)
+}
+
+// newRequest creates the request with the github headers, conditional if the cached entry is known.
+func (s *httpSource) newRequest(ctx context.Context, rawurl string, cached *cacheEntry) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create request for %v", rawurl)
+	}
+	if s.githubToken != "" && s.isGithub(rawurl) {
+		req.Header.Set("Authorization", "token "+s.githubToken)
+	}
+	if strings.HasPrefix(rawurl, s.githubAPI+"/") {
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	return req, nil
+}
+
+// handleResponse classifies the response status, see do for the returned values.
+func handleResponse(rawurl string, resp *http.Response, cached *cacheEntry) (entry *cacheEntry, retryAfter time.Duration, err error) {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to read response from %v", rawurl)
+		}
+		return &cacheEntry{
+			URL:          rawurl,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Immutable:    isImmutable(rawurl),
+			body:         b,
+		}, 0, nil
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, 0, nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, parseRetryAfter(resp.Header), errors.Errorf("failed to get %v: %v", rawurl, resp.Status)
+	case isRateLimited(resp):
+		retryAfter = parseRetryAfter(resp.Header)
+		if retryAfter == 0 {
+			retryAfter = time.Until(parseResetTime(resp.Header))
+		}
+		if retryAfter <= 0 {
+			retryAfter = 0
+		}
+		return nil, retryAfter, errors.Errorf("failed to get %v: %v: rate limit exceeded", rawurl, resp.Status)
+	default:
+		return nil, -1, &statusError{url: rawurl, status: resp.Status}
+	}
+}
+
+// statusError means that the server definitely rejected the request.
+type statusError struct {
+	url, status string
+}
+
+func (e *statusError) Error() string {
+	return "failed to get " + e.url + ": " + e.status
+}
+
+func (s *httpSource) sleep(ctx context.Context, d time.Duration) error {
+	var timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isGithub returns true if the url points to the github API or the github raw content.
+func (s *httpSource) isGithub(rawurl string) bool {
+	return strings.HasPrefix(rawurl, s.githubRaw+"/") || strings.HasPrefix(rawurl, s.githubAPI+"/")
+}
+
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	return resp.Header.Get(rateLimitRemainingHeader) == "0" || resp.Header.Get(retryAfterHeader) != ""
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+	var value = header.Get(retryAfterHeader)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func parseResetTime(header http.Header) time.Time {
+	seconds, err := strconv.ParseInt(header.Get(rateLimitResetHeader), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -18,17 +18,12 @@
 package images
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
-	"regexp"
+	"context"
+	"path"
 	"strings"
+	"sync"
 
-	"gopkg.in/yaml.v3"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -37,7 +32,9 @@ const (
 
 // ImageList represents list of open container images
 type ImageList struct {
-	Images []string
+	Images []string `json:"images" yaml:"images"`
+	// Files are the images found in each file by the file URL. Rendered kustomizations are keyed by the kustomization file.
+	Files map[string][]string `json:"-" yaml:"-"`
 }
 
 // ReteriveList gets list of all images from the source.
@@ -45,174 +42,132 @@ type ImageList struct {
 // 1. Local files: file://..
 // 2. Remote gettable content: https://raw.githubusercontent.com/...
 // 3. Remote files and dirs via github api: https://api.github.com/repos/...
+// Other formats can be supported by a Source registered for the scheme or the host.
+// Local directories with a kustomization file are rendered, so the images reflect kustomize image overrides.
+// Errors are ignored, see ReteriveListContext for the error aware version.
 func ReteriveList(sources []string, match func(string) bool) *ImageList {
-	var result = new(ImageList)
-	var filesURls []string
-
-	for _, source := range sources {
-		filesURls = append(filesURls, reteriveFileList(source, match)...)
-	}
-
-	for _, fileURL := range filesURls {
-		var content = readContent(fileURL)
-		var images = getImages(content)
-		result.Images = append(result.Images, images...)
-	}
-
+	var result, _ = ReteriveListContext(context.Background(), sources, match)
 	return result
 }
 
-func getImages(content []byte) []string {
-	var imagesList ImageList
-
-	_ = yaml.Unmarshal(content, &imagesList)
-
-	if len(imagesList.Images) > 0 {
-		return imagesList.Images
-	}
-
-	var imagePattern = regexp.MustCompile(".*image: (?P<image>.*)")
-	var imageSubexpIndex = imagePattern.SubexpIndex("image")
-	var result []string
+// ReteriveListContext gets list of all images from the source like ReteriveList does.
+// Returns images from all readable files and Errors with one SourceError per failed source.
+func ReteriveListContext(ctx context.Context, sources []string, match func(string) bool, opts ...Option) (*ImageList, error) {
+	var r = newRetriever(ctx, newOptions(opts))
+	var result = &ImageList{Files: map[string][]string{}}
+	var errs Errors
 
-	if imagePattern.Match(content) {
-		for _, match := range imagePattern.FindAllStringSubmatch(string(content), -1) {
-			result = append(result, match[imageSubexpIndex])
+	for _, source := range sources {
+		if err := r.reteriveSource(result, source, match); err != nil {
+			errs = append(errs, &SourceError{Source: source, Err: err})
 		}
 	}
-	return result
-}
 
-func readContent(rawurl string) []byte {
-	var u, _ = url.Parse(rawurl)
-	if u.Scheme == fileScheme {
-		var p = filepath.Join(u.Hostname(), u.Path)
-		b, err := ioutil.ReadFile(filepath.Clean(p))
-		if err == nil {
-			return b
-		}
-		return nil
+	if len(errs) > 0 {
+		return result, errs
 	}
-	if u.Scheme == "http" || u.Scheme == "https" {
-		// #nosec
-		resp, err := http.Get(rawurl)
-		if err != nil {
-			return nil
-		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil
-		}
-		return b
-	}
-
-	return nil
+	return result, nil
 }
 
-func reteriveLocalFileList(rawurl string, match func(string) bool) []string {
-	u, err := url.Parse(rawurl)
-	if err != nil {
-		return nil
-	}
+// retriever reads sources within a single ReteriveListContext call.
+type retriever struct {
+	ctx context.Context
+	*options
+	sources map[string]Source
+}
 
-	basePath := filepath.Join(u.Hostname(), u.Path)
+func (r *retriever) reteriveSource(result *ImageList, source string, match func(string) bool) error {
+	var errs Errors
 
-	root, err := os.Open(filepath.Clean(basePath))
+	filesURLs, err := r.reteriveFileList(source, match)
 	if err != nil {
-		return nil
-	}
-
-	stat, err := root.Stat()
-	if err != nil {
-		return nil
+		errs = append(errs, err)
+	}
+
+	var images = make([][]string, len(filesURLs))
+	var fileErrs = make([]error, len(filesURLs))
+	var jobs = make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < r.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				images[i], fileErrs[i] = r.reteriveFile(filesURLs[i])
+			}
+		}()
 	}
-
-	if !stat.IsDir() {
-		return []string{fmt.Sprintf("%v://%v", fileScheme, basePath)}
+	for i := range filesURLs {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	var result []string
-
-	files, err := ioutil.ReadDir(basePath)
-	if err != nil {
-		return nil
-	}
-
-	for _, f := range files {
-		var p = fmt.Sprintf("%v://%v", fileScheme, filepath.Join(basePath, f.Name()))
-		if f.IsDir() {
-			result = append(result, reteriveFileList(p, match)...)
-		} else if match(f.Name()) {
-			result = append(result, p)
+	for i := range filesURLs {
+		result.Images = append(result.Images, images[i]...)
+		if len(images[i]) > 0 {
+			result.Files[filesURLs[i]] = append(result.Files[filesURLs[i]], images[i]...)
+		}
+		if fileErrs[i] != nil {
+			errs = append(errs, fileErrs[i])
 		}
 	}
-	return result
-}
-
-func reteriveGithubFileList(rawurl string, match func(string) bool) []string {
-	var b = readContent(rawurl)
 
-	if b == nil {
-		return nil
+	if len(errs) > 0 {
+		return errs
 	}
+	return nil
+}
 
-	var objects []map[string]interface{}
-	var result []string
-
-	if err := json.Unmarshal(b, &objects); err != nil {
-		var object map[string]interface{}
-		if err = json.Unmarshal(b, &object); err != nil {
-			return nil
+func (r *retriever) reteriveFile(fileURL string) ([]string, error) {
+	var images []string
+	var err error
+	if strings.HasPrefix(fileURL, "file://") && isKustomization(path.Base(fileURL)) {
+		images, err = r.renderKustomization(fileURL[:strings.LastIndex(fileURL, "/")])
+	} else {
+		var content []byte
+		if content, err = r.readContent(fileURL); err != nil {
+			return nil, err
+		}
+		if images, err = getImages(content); err != nil {
+			err = errors.Wrapf(err, "%v", fileURL)
 		}
-		objects = append(objects, object)
 	}
 
-	for _, obj := range objects {
-		if _, ok := obj["path"]; !ok {
-			continue
-		}
-		if _, ok := obj["type"]; !ok {
+	var errs Errors
+	if err != nil {
+		errs = append(errs, err)
+	}
+	var result = images[:0]
+	for _, image := range images {
+		if _, parseErr := ParseReference(image); parseErr != nil {
+			errs = append(errs, errors.Wrapf(parseErr, "%v", fileURL))
 			continue
 		}
-		var p = obj["path"].(string)
-
-		if obj["type"] == "file" {
-			if match(obj["name"].(string)) {
-				result = append(result, obj["download_url"].(string))
-			}
-		}
-
-		if obj["type"] == "dir" {
-			var nextContentsURL = apiContentsURL(rawurl, p)
-			result = append(result, reteriveFileList(nextContentsURL, match)...)
-		}
+		result = append(result, image)
 	}
 
-	return result
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
 }
 
-func reteriveFileList(u string, match func(string) bool) []string {
-	if strings.HasPrefix(u, "https://raw.githubusercontent.com") {
-		return []string{u}
-	}
-	if strings.HasPrefix(u, "file://") {
-		return reteriveLocalFileList(u, match)
-	}
-	if strings.HasPrefix(u, "https://api.github.com/repos/") {
-		return reteriveGithubFileList(u, match)
+func (r *retriever) readContent(rawurl string) ([]byte, error) {
+	source, err := r.source(rawurl)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return source.Read(r.ctx, rawurl)
 }
 
-func apiContentsURL(contentsURL, newPath string) string {
-	var u, _ = url.Parse(contentsURL)
-	var segments = strings.Split(u.Path, string(filepath.Separator))
-
-	segments = append(segments[:5], newPath)
-	u.Path = strings.Join(segments, string(filepath.Separator))
-
-	return u.String()
+func (r *retriever) reteriveFileList(u string, match func(string) bool) ([]string, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to list %v", u)
+	}
+	source, err := r.source(u)
+	if err != nil {
+		return nil, err
+	}
+	return source.List(r.ctx, u, match)
 }
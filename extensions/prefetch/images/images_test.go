@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -19,7 +19,9 @@
 package images_test
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
@@ -51,10 +53,120 @@ func ExampleReteriveList() {
 	// ghcr.io/networkservicemesh/cmd-nsc:v1.0.0
 	// ghcr.io/networkservicemesh/cmd-nsc:v1.0.0
 	// alpine
+	// ghcr.io/networkservicemesh/cmd-nsc:v1.0.0
+	// ghcr.io/networkservicemesh/cmd-nsc:v1.1.0
+	// docker.io/library/alpine:3.15
 	// image1
 	// image2
+	// nginx:1.21
+	// busybox:1.34
+	// curlimages/curl
 	// alpine
+	// ghcr.io/networkservicemesh/cmd-nsc:v1.0.0
+	// ghcr.io/networkservicemesh/cmd-nsc:v1.1.0
+	// docker.io/library/alpine:3.15
 	// image1
 	// image2
+	// nginx:1.21
+	// busybox:1.34
+	// curlimages/curl
+	// alpine
+}
+
+func ExampleReteriveList_manifests() {
+	var list = images.ReteriveList([]string{"file://samples/workloads.yaml"}, yamlFileMatch)
+
+	for _, image := range list.Images {
+		fmt.Println(image)
+	}
+
+	// Output:
+	// nginx:1.21
+	// busybox:1.34
+	// curlimages/curl
+}
+
+func ExampleReteriveList_kustomize() {
+	var list = images.ReteriveList([]string{"file://samples/kustomize/overlay"}, yamlFileMatch)
+
+	for _, image := range list.Images {
+		fmt.Println(image)
+	}
+
+	// Output:
+	// ghcr.io/networkservicemesh/cmd-nsc:v1.1.0
+	// docker.io/library/alpine:3.15
+}
+
+func ExampleReteriveListContext() {
+	var sources = []string{
+		"file://samples/alpine.yaml",
+		"file://samples/missing.yaml",
+	}
+
+	var list, err = images.ReteriveListContext(context.Background(), sources, yamlFileMatch)
+
+	for _, image := range list.Images {
+		fmt.Println(image)
+	}
+	for _, sourceErr := range err.(images.Errors) {
+		fmt.Println(sourceErr.(*images.SourceError).Source)
+	}
+
+	// Output:
+	// alpine
+	// file://samples/missing.yaml
+}
+
+// memorySource is a Source that serves files from memory: mem://file-name
+type memorySource map[string]string
+
+func (m memorySource) List(_ context.Context, _ string, match func(string) bool) ([]string, error) {
+	var result []string
+	for name := range m {
+		if match(name) {
+			result = append(result, "mem://"+name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (m memorySource) Read(_ context.Context, location string) ([]byte, error) {
+	return []byte(m[strings.TrimPrefix(location, "mem://")]), nil
+}
+
+func ExampleWithSource() {
+	var source = memorySource{
+		"a.yaml": "images: [image-a]",
+		"b.yaml": "images: [image-b]",
+		"c.txt":  "images: [image-c]",
+	}
+
+	var list, _ = images.ReteriveListContext(context.Background(), []string{"mem://"}, yamlFileMatch, images.WithSource("mem", source))
+
+	for _, image := range list.Images {
+		fmt.Println(image)
+	}
+
+	// Output:
+	// image-a
+	// image-b
+}
+
+func ExampleReteriveListContext_invalidReference() {
+	var source = memorySource{
+		"a.yaml": "images: [alpine, Alpine]",
+	}
+
+	var list, err = images.ReteriveListContext(context.Background(), []string{"mem://"}, yamlFileMatch, images.WithSource("mem", source))
+
+	for _, image := range list.Images {
+		fmt.Println(image)
+	}
+	fmt.Println(err)
+
+	// Output:
 	// alpine
+	// source mem://: mem://a.yaml: invalid image reference "Alpine": invalid repository "library/Alpine"
 }
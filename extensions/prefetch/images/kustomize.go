@@ -0,0 +1,283 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var kustomizationFileNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+type imageTransformer struct {
+	Name    string `yaml:"name"`
+	NewName string `yaml:"newName"`
+	NewTag  string `yaml:"newTag"`
+	Digest  string `yaml:"digest"`
+}
+
+type kustomization struct {
+	Resources             []string `yaml:"resources"`
+	Bases                 []string `yaml:"bases"`
+	Components            []string `yaml:"components"`
+	PatchesStrategicMerge []string `yaml:"patchesStrategicMerge"`
+	Patches               []struct {
+		Path  string `yaml:"path"`
+		Patch string `yaml:"patch"`
+	} `yaml:"patches"`
+	Images []imageTransformer `yaml:"images"`
+}
+
+// isKustomization returns true if the file name is one of the names recognized by kustomize.
+func isKustomization(name string) bool {
+	for _, n := range kustomizationFileNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// renderKustomization returns images that will be deployed by the kustomization located in the dir.
+// Local and remote github bases are resolved, image transformers and strategic merge patches are applied.
+func (r *retriever) renderKustomization(dir string) ([]string, error) {
+	var k = kustomizer{retriever: r, visited: map[string]bool{}}
+	var result []string
+	for _, r := range k.render(dir) {
+		result = append(result, r.images()...)
+	}
+	if len(k.errs) > 0 {
+		return result, k.errs
+	}
+	return result, nil
+}
+
+type kustomizer struct {
+	*retriever
+	visited map[string]bool
+	errs    Errors
+}
+
+func (k *kustomizer) render(dir string) []*resource {
+	if k.visited[dir] {
+		return nil
+	}
+	k.visited[dir] = true
+
+	var content []byte
+	var err error
+	for _, name := range kustomizationFileNames {
+		if content, err = k.readContent(joinLocation(dir, name)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		k.errs = append(k.errs, errors.Errorf("kustomization is not found in %v", dir))
+		return nil
+	}
+
+	var kust kustomization
+	if err = yaml.Unmarshal(content, &kust); err != nil {
+		k.errs = append(k.errs, errors.Wrapf(err, "failed to decode kustomization in %v", dir))
+		return nil
+	}
+
+	var result = k.resources(dir, &kust)
+	var patches = k.patches(dir, &kust)
+	for _, p := range patches {
+		result = applyPatch(result, p)
+	}
+
+	for _, r := range result {
+		for i := range r.containers {
+			r.containers[i].Image = transformImage(r.containers[i].Image, kust.Images)
+		}
+	}
+
+	return result
+}
+
+// resources renders resources, bases and components of the kustomization.
+func (k *kustomizer) resources(dir string, kust *kustomization) []*resource {
+	var result []*resource
+	for _, lists := range [][]string{kust.Resources, kust.Bases, kust.Components} {
+		for _, ref := range lists {
+			var location = joinLocation(dir, ref)
+			if isManifestFile(location) {
+				result = append(result, k.decode(location)...)
+				continue
+			}
+			result = append(result, k.render(location)...)
+		}
+	}
+	return result
+}
+
+// patches decodes strategic merge patches of the kustomization, both from files and inline.
+func (k *kustomizer) patches(dir string, kust *kustomization) []*resource {
+	var result []*resource
+	for _, p := range kust.PatchesStrategicMerge {
+		result = append(result, k.decode(joinLocation(dir, p))...)
+	}
+	for _, p := range kust.Patches {
+		if p.Path != "" {
+			result = append(result, k.decode(joinLocation(dir, p.Path))...)
+		}
+		if p.Patch != "" {
+			resources, err := decodeResources([]byte(p.Patch))
+			if err != nil {
+				k.errs = append(k.errs, errors.Wrapf(err, "failed to decode inline patch in %v", dir))
+			}
+			result = append(result, resources...)
+		}
+	}
+	return result
+}
+
+func (k *kustomizer) decode(location string) []*resource {
+	content, err := k.readContent(location)
+	if err != nil {
+		k.errs = append(k.errs, err)
+		return nil
+	}
+	result, err := decodeResources(content)
+	if err != nil {
+		k.errs = append(k.errs, errors.Wrapf(err, "%v", location))
+	}
+	return result
+}
+
+// applyPatch merges containers of the patch into the resource with the same kind and name.
+func applyPatch(resources []*resource, patch *resource) []*resource {
+	for _, r := range resources {
+		if r.kind != patch.kind || r.name != patch.name {
+			continue
+		}
+		for _, pc := range patch.containers {
+			var merged bool
+			for i := range r.containers {
+				if r.containers[i].Name == pc.Name {
+					if pc.Image != "" {
+						r.containers[i].Image = pc.Image
+					}
+					merged = true
+				}
+			}
+			if !merged {
+				r.containers = append(r.containers, pc)
+			}
+		}
+		break
+	}
+	return resources
+}
+
+// transformImage applies the first matching kustomize image transformer to the image.
+func transformImage(image string, transformers []imageTransformer) string {
+	var name, tag, digest = splitImage(image)
+	for _, t := range transformers {
+		if t.Name != name {
+			continue
+		}
+		if t.NewName != "" {
+			name = t.NewName
+		}
+		if t.NewTag != "" {
+			tag, digest = t.NewTag, ""
+		}
+		if t.Digest != "" {
+			tag, digest = "", t.Digest
+		}
+		break
+	}
+	var result = name
+	if tag != "" {
+		result += ":" + tag
+	}
+	if digest != "" {
+		result += "@" + digest
+	}
+	return result
+}
+
+// splitImage splits the image into the name, the tag and the digest.
+func splitImage(image string) (name, tag, digest string) {
+	name = image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+func isManifestFile(location string) bool {
+	var u, err = url.Parse(location)
+	if err != nil {
+		return false
+	}
+	switch path.Ext(u.Path) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// joinLocation resolves the kustomize reference relative to the base location.
+// Remote github references are converted into raw.githubusercontent.com locations.
+func joinLocation(base, ref string) string {
+	if strings.HasPrefix(ref, "github.com/") {
+		ref = "https://" + ref
+	}
+	if u, err := url.Parse(ref); err == nil && u.Scheme != "" {
+		if u.Host == "github.com" {
+			return githubRawLocation(u)
+		}
+		return ref
+	}
+
+	var u, err = url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme == fileScheme {
+		return fmt.Sprintf("%v://%v", fileScheme, filepath.Join(u.Hostname(), u.Path, ref))
+	}
+	u.Path = path.Join(u.Path, ref)
+	return u.String()
+}
+
+// githubRawLocation converts github.com/org/repo[.git][/]/path?ref=version into a raw content location.
+func githubRawLocation(u *url.URL) string {
+	var segments = strings.Split(strings.Trim(path.Clean(u.Path), "/"), "/")
+	if len(segments) < 2 {
+		return ""
+	}
+	var ref = u.Query().Get("ref")
+	if ref == "" {
+		ref = "HEAD"
+	}
+	var repo = strings.TrimSuffix(segments[1], ".git")
+	return "https://" + path.Join("raw.githubusercontent.com", segments[0], repo, ref, path.Join(segments[2:]...))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type localSource struct{}
+
+// NewLocalSource returns the Source for local files and directories: file://...
+// Files of directories with a kustomization file are represented by the kustomization file only.
+func NewLocalSource() Source {
+	return new(localSource)
+}
+
+func (s *localSource) Read(_ context.Context, rawurl string) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", rawurl)
+	}
+	var p = filepath.Join(u.Hostname(), u.Path)
+	b, err := ioutil.ReadFile(filepath.Clean(p))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", rawurl)
+	}
+	return b, nil
+}
+
+func (s *localSource) List(ctx context.Context, rawurl string, match func(string) bool) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to list %v", rawurl)
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", rawurl)
+	}
+
+	basePath := filepath.Join(u.Hostname(), u.Path)
+
+	root, err := os.Open(filepath.Clean(basePath))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %v", rawurl)
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	stat, err := root.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %v", rawurl)
+	}
+
+	if !stat.IsDir() {
+		return []string{fmt.Sprintf("%v://%v", fileScheme, basePath)}, nil
+	}
+
+	files, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read dir %v", rawurl)
+	}
+
+	result, files := kustomizationFiles(basePath, files, match)
+
+	var errs Errors
+	for _, f := range files {
+		var p = fmt.Sprintf("%v://%v", fileScheme, filepath.Join(basePath, f.Name()))
+		if f.IsDir() {
+			list, err := s.List(ctx, p, match)
+			result = append(result, list...)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		} else if match(filepath.Join(basePath, f.Name())) {
+			result = append(result, p)
+		}
+	}
+
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// kustomizationFiles represents files of the kustomize directory by the kustomization file only.
+// Returns the matched kustomization file and the files left to list.
+func kustomizationFiles(basePath string, files []os.FileInfo, match func(string) bool) ([]string, []os.FileInfo) {
+	for _, f := range files {
+		if f.IsDir() || !isKustomization(f.Name()) {
+			continue
+		}
+		var result []string
+		if match(filepath.Join(basePath, f.Name())) {
+			result = append(result, fmt.Sprintf("%v://%v", fileScheme, filepath.Join(basePath, f.Name())))
+		}
+		return result, onlyDirs(files)
+	}
+	return nil, files
+}
+
+func onlyDirs(files []os.FileInfo) []os.FileInfo {
+	var result []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() {
+			result = append(result, f)
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type container struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+}
+
+type podSpec struct {
+	Containers          []container `yaml:"containers"`
+	InitContainers      []container `yaml:"initContainers"`
+	EphemeralContainers []container `yaml:"ephemeralContainers"`
+}
+
+type podTemplate struct {
+	Spec podSpec `yaml:"spec"`
+}
+
+type workloadSpec struct {
+	Template podTemplate `yaml:"template"`
+}
+
+type cronJobSpec struct {
+	JobTemplate struct {
+		Spec workloadSpec `yaml:"spec"`
+	} `yaml:"jobTemplate"`
+}
+
+type document struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Images yaml.Node `yaml:"images"`
+	Spec   yaml.Node `yaml:"spec"`
+}
+
+// resource is a decoded document that can hold images. ImageList documents have an empty kind.
+type resource struct {
+	kind, name string
+	containers []container
+}
+
+// getImages decodes all documents from the content and returns images found in them.
+// Documents can be either an ImageList or k8s workload manifests.
+func getImages(content []byte) ([]string, error) {
+	var result []string
+	resources, err := decodeResources(content)
+	for _, r := range resources {
+		result = append(result, r.images()...)
+	}
+	return result, err
+}
+
+func decodeResources(content []byte) ([]*resource, error) {
+	var result []*resource
+
+	var decoder = yaml.NewDecoder(bytes.NewReader(content))
+	for i := 0; ; i++ {
+		var doc document
+		if err := decoder.Decode(&doc); err != nil {
+			if err != io.EOF {
+				return result, errors.Wrapf(err, "failed to decode document %v", i)
+			}
+			break
+		}
+		r, err := doc.resource()
+		if err != nil {
+			return result, errors.Wrapf(err, "failed to decode document %v", i)
+		}
+		if r != nil {
+			result = append(result, r)
+		}
+	}
+
+	return result, nil
+}
+
+func (d *document) resource() (*resource, error) {
+	var result = &resource{
+		kind: d.Kind,
+		name: d.Metadata.Name,
+	}
+
+	if d.Kind == "" {
+		var list []string
+		if d.Images.Kind == 0 {
+			// Decoding of the missing field panics
+			return nil, nil
+		}
+		if err := d.Images.Decode(&list); err != nil || len(list) == 0 {
+			// Not an ImageList
+			return nil, nil
+		}
+		for _, image := range list {
+			result.containers = append(result.containers, container{Image: image})
+		}
+		return result, nil
+	}
+
+	if d.Spec.Kind == 0 {
+		return nil, nil
+	}
+	var spec podSpec
+	switch d.Kind {
+	case "Pod":
+		if err := d.Spec.Decode(&spec); err != nil {
+			return nil, errors.Wrapf(err, "invalid %v %v", d.Kind, d.Metadata.Name)
+		}
+	case "Deployment", "DaemonSet", "StatefulSet", "ReplicaSet", "Job":
+		var w workloadSpec
+		if err := d.Spec.Decode(&w); err != nil {
+			return nil, errors.Wrapf(err, "invalid %v %v", d.Kind, d.Metadata.Name)
+		}
+		spec = w.Template.Spec
+	case "CronJob":
+		var c cronJobSpec
+		if err := d.Spec.Decode(&c); err != nil {
+			return nil, errors.Wrapf(err, "invalid %v %v", d.Kind, d.Metadata.Name)
+		}
+		spec = c.JobTemplate.Spec.Template.Spec
+	default:
+		return nil, nil
+	}
+
+	for _, containers := range [][]container{spec.Containers, spec.InitContainers, spec.EphemeralContainers} {
+		result.containers = append(result.containers, containers...)
+	}
+
+	return result, nil
+}
+
+func (r *resource) images() []string {
+	var result []string
+	for _, c := range r.containers {
+		if c.Image != "" {
+			result = append(result, c.Image)
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGithubAPI = "https://api.github.com"
+	defaultGithubRaw = "https://raw.githubusercontent.com"
+)
+
+type options struct {
+	client       *http.Client
+	githubAPI    string
+	githubRaw    string
+	githubToken  string
+	retries      int
+	retryBackoff time.Duration
+	maxWait      time.Duration
+	concurrency  int
+	sources      map[string]Source
+	cacheDir     string
+}
+
+// Option is an option for ReteriveListContext.
+type Option func(o *options)
+
+// WithHTTPClient sets the http client used for remote sources.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithGithubAPI sets the base URL of the github API. Default: https://api.github.com.
+func WithGithubAPI(apiURL string) Option {
+	return func(o *options) {
+		o.githubAPI = strings.TrimSuffix(apiURL, "/")
+	}
+}
+
+// WithGithubRaw sets the base URL of the github raw content. Default: https://raw.githubusercontent.com.
+func WithGithubRaw(rawURL string) Option {
+	return func(o *options) {
+		o.githubRaw = strings.TrimSuffix(rawURL, "/")
+	}
+}
+
+// WithGithubToken sets the token used to authenticate requests to github.
+func WithGithubToken(token string) Option {
+	return func(o *options) {
+		o.githubToken = token
+	}
+}
+
+// WithRetry sets the number of retries of failed remote requests and the initial exponential backoff between them.
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithMaxWait sets the maximum time to wait for a single retry, including waiting for the github rate limit reset.
+func WithMaxWait(maxWait time.Duration) Option {
+	return func(o *options) {
+		o.maxWait = maxWait
+	}
+}
+
+// WithConcurrency sets the number of files that are read concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(o *options) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithCacheDir enables caching of remote content in the directory. Cached content is revalidated
+// with ETag and Last-Modified and is used if the server is not reachable. Content of commit SHAs never expires.
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithSource uses the source for locations with the scheme or the host instead of the registered one.
+func WithSource(schemeOrHost string, source Source) Option {
+	return func(o *options) {
+		if o.sources == nil {
+			o.sources = map[string]Source{}
+		}
+		o.sources[strings.ToLower(schemeOrHost)] = source
+	}
+}
+
+func newOptions(opts []Option) *options {
+	var o = &options{
+		client:       http.DefaultClient,
+		githubAPI:    defaultGithubAPI,
+		githubRaw:    defaultGithubRaw,
+		retries:      5,
+		retryBackoff: time.Second,
+		maxWait:      time.Minute,
+		concurrency:  8,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	return o
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRegistry  = "docker.io"
+	legacyRegistry   = "index.docker.io"
+	officialRepoName = "library"
+	defaultTag       = "latest"
+	maxNameLength    = 255
+)
+
+var (
+	componentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	domainPattern    = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	tagPattern       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// Reference is a parsed container image reference.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses the image reference and normalizes it the same way docker does:
+// the registry defaults to docker.io, official docker.io images are prefixed with library/
+// and the tag defaults to latest if there is no digest.
+func ParseReference(image string) (*Reference, error) {
+	var result = new(Reference)
+
+	name, err := result.splitDigestAndTag(image)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.Errorf("invalid image reference %q: empty name", image)
+	}
+	if len(name) > maxNameLength {
+		return nil, errors.Errorf("invalid image reference %q: name is longer than %v characters", image, maxNameLength)
+	}
+	if err := result.splitDomain(image, name); err != nil {
+		return nil, err
+	}
+	if err := result.validateRepository(image); err != nil {
+		return nil, err
+	}
+
+	if result.Tag == "" && result.Digest == "" {
+		result.Tag = defaultTag
+	}
+
+	return result, nil
+}
+
+// splitDigestAndTag sets the digest and the tag of the image and returns the name.
+func (r *Reference) splitDigestAndTag(image string) (string, error) {
+	var name = image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !digestPattern.MatchString(r.Digest) {
+			return "", errors.Errorf("invalid image reference %q: invalid digest %q", image, r.Digest)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(r.Tag) {
+			return "", errors.Errorf("invalid image reference %q: invalid tag %q", image, r.Tag)
+		}
+	}
+	return name, nil
+}
+
+// splitDomain sets the registry and the repository of the name.
+func (r *Reference) splitDomain(image, name string) error {
+	r.Registry, r.Repository = defaultRegistry, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		var domain = name[:i]
+		if strings.ContainsAny(domain, ".:") || domain == "localhost" || strings.ToLower(domain) != domain {
+			if !domainPattern.MatchString(domain) {
+				return errors.Errorf("invalid image reference %q: invalid registry %q", image, domain)
+			}
+			r.Registry, r.Repository = domain, name[i+1:]
+		}
+	}
+	if r.Registry == legacyRegistry {
+		r.Registry = defaultRegistry
+	}
+	if r.Registry == defaultRegistry && !strings.Contains(r.Repository, "/") {
+		r.Repository = officialRepoName + "/" + r.Repository
+	}
+	return nil
+}
+
+func (r *Reference) validateRepository(image string) error {
+	for _, component := range strings.Split(r.Repository, "/") {
+		if !componentPattern.MatchString(component) {
+			return errors.Errorf("invalid image reference %q: invalid repository %q", image, r.Repository)
+		}
+	}
+	return nil
+}
+
+// Name returns the normalized name of the image without the tag and the digest.
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the normalized reference.
+func (r *Reference) String() string {
+	var result = r.Name()
+	if r.Tag != "" {
+		result += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		result += "@" + r.Digest
+	}
+	return result
+}
+
+// Deduplicate removes images that have the same normalized reference. The first occurrence is kept as is.
+// Invalid references are compared as is.
+func Deduplicate(images []string) []string {
+	var visited = make(map[string]bool)
+	var result []string
+	for _, image := range images {
+		var key = image
+		if ref, err := ParseReference(image); err == nil {
+			key = ref.String()
+		}
+		if !visited[key] {
+			visited[key] = true
+			result = append(result, image)
+		}
+	}
+	return result
+}
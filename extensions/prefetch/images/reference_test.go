@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestParseReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for image, expected := range map[string]string{
+		"alpine":                                    "docker.io/library/alpine:latest",
+		"alpine:latest":                             "docker.io/library/alpine:latest",
+		"docker.io/library/alpine:latest":           "docker.io/library/alpine:latest",
+		"index.docker.io/alpine":                    "docker.io/library/alpine:latest",
+		"rrandom312/return":                         "docker.io/rrandom312/return:latest",
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0": "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+		"localhost/image":                           "localhost/image:latest",
+		"localhost:5000/nsm/cmd-nsc:v1.0.0":         "localhost:5000/nsm/cmd-nsc:v1.0.0",
+		"alpine@" + digest:                          "docker.io/library/alpine@" + digest,
+		"alpine:3.15@" + digest:                     "docker.io/library/alpine:3.15@" + digest,
+	} {
+		ref, err := images.ParseReference(image)
+		require.NoError(t, err, image)
+		require.Equal(t, expected, ref.String(), image)
+	}
+
+	for _, image := range []string{
+		"",
+		"Alpine",
+		"alpine:",
+		"alpine:latest\"",
+		"'alpine'",
+		"alpine@sha256:123",
+		"ghcr.io/networkservicemesh/cmd nsc",
+		":latest",
+	} {
+		_, err := images.ParseReference(image)
+		require.Error(t, err, image)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	require.Equal(t, []string{"alpine", "ghcr.io/networkservicemesh/cmd-nsc:v1.0.0"}, images.Deduplicate([]string{
+		"alpine",
+		"docker.io/library/alpine:latest",
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+		"alpine:latest",
+	}))
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Rewriter replaces images with their mirrors before prefetching.
+// Mirrors and rules are matched against the normalized image reference, e.g. docker.io/library/alpine:latest for alpine.
+type Rewriter struct {
+	mirrors map[string]string
+	rules   []*rewriteRule
+}
+
+type rewriteRule struct {
+	regexp      *regexp.Regexp
+	replacement string
+}
+
+// NewRewriter creates rewriter from mirrors in the `prefix=mirror` form and rules in the `regex=replacement` form.
+// Mirrors are applied first, the longest matching prefix wins. Rules are applied in order to the result.
+func NewRewriter(mirrors, rules []string) (*Rewriter, error) {
+	var r = &Rewriter{mirrors: map[string]string{}}
+	for _, mirror := range mirrors {
+		prefix, replacement, err := splitRewrite(mirror)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid image mirror")
+		}
+		r.mirrors[strings.TrimSuffix(prefix, "/")] = strings.TrimSuffix(replacement, "/")
+	}
+	for _, rule := range rules {
+		expr, replacement, err := splitRewrite(rule)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid image rewrite")
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid image rewrite %q", rule)
+		}
+		r.rules = append(r.rules, &rewriteRule{regexp: re, replacement: replacement})
+	}
+	return r, nil
+}
+
+func splitRewrite(s string) (from, to string, err error) {
+	var i = strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", errors.Errorf("%q is not in the from=to form", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
+
+// Rewrite returns the image to prefetch instead of the passed one. The image is returned as is if nothing matches.
+func (r *Rewriter) Rewrite(image string) string {
+	var name = image
+	if ref, err := ParseReference(image); err == nil {
+		name = ref.String()
+	}
+
+	var result = name
+	var longest = -1
+	for prefix, mirror := range r.mirrors {
+		if len(prefix) > longest && (name == prefix || strings.HasPrefix(name, prefix+"/")) {
+			longest = len(prefix)
+			result = mirror + name[len(prefix):]
+		}
+	}
+	for _, rule := range r.rules {
+		result = rule.regexp.ReplaceAllString(result, rule.replacement)
+	}
+
+	if result == name {
+		return image
+	}
+	return result
+}
+
+// RewriteAll rewrites images, logs rewritten ones and removes duplicates of the result.
+func (r *Rewriter) RewriteAll(list []string) []string {
+	var result = make([]string, 0, len(list))
+	for _, image := range list {
+		var rewritten = r.Rewrite(image)
+		if rewritten != image {
+			logrus.Infof("Image %v is prefetched as %v", image, rewritten)
+		}
+		result = append(result, rewritten)
+	}
+	return Deduplicate(result)
+}
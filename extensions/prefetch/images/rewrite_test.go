@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestRewriter(t *testing.T) {
+	r, err := images.NewRewriter(
+		[]string{"docker.io=localhost:5000/dockerhub", "ghcr.io=mirror.local/ghcr", "ghcr.io/networkservicemesh=mirror.local/nsm/"},
+		[]string{`^mirror\.local/ghcr/(.*):latest$=mirror.local/ghcr/$1:main`},
+	)
+	require.NoError(t, err)
+
+	for image, expected := range map[string]string{
+		"alpine": "localhost:5000/dockerhub/library/alpine:latest",
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0": "mirror.local/nsm/cmd-nsc:v1.0.0",
+		"ghcr.io/other/app":                         "mirror.local/ghcr/other/app:main",
+		"ghcr.io.example.com/app:v1":                "ghcr.io.example.com/app:v1",
+		"registry.k8s.io/pause:3.6":                 "registry.k8s.io/pause:3.6",
+	} {
+		require.Equal(t, expected, r.Rewrite(image), image)
+	}
+
+	require.Equal(t, []string{"localhost:5000/dockerhub/library/alpine:latest"}, r.RewriteAll([]string{"alpine", "docker.io/alpine:latest"}))
+}
+
+func TestRewriter_Invalid(t *testing.T) {
+	_, err := images.NewRewriter([]string{"ghcr.io"}, nil)
+	require.Error(t, err)
+
+	_, err = images.NewRewriter(nil, []string{"(=x"})
+	require.Error(t, err)
+}
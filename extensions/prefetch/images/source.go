@@ -0,0 +1,102 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Source lists and reads files of images sources.
+type Source interface {
+	// List returns locations of all files of the location matching the filter. The filter is called with the file path,
+	// e.g. the path in the repository for github and the local path for file:// locations.
+	List(ctx context.Context, location string, match func(string) bool) ([]string, error)
+	// Read returns the content of the file location.
+	Read(ctx context.Context, location string) ([]byte, error)
+}
+
+var registry = struct {
+	sync.RWMutex
+	sources map[string]Source
+}{
+	sources: map[string]Source{},
+}
+
+// Register registers the source for all locations with the scheme or the host, e.g. "s3" or "gitlab.com".
+// Hosts take precedence over schemes. Registered sources replace the built-in ones.
+func Register(schemeOrHost string, source Source) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.sources[strings.ToLower(schemeOrHost)] = source
+}
+
+func newRetriever(ctx context.Context, o *options) *retriever {
+	var httpSource = &httpSource{options: o}
+	var r = &retriever{
+		ctx:     ctx,
+		options: o,
+		sources: map[string]Source{
+			fileScheme: NewLocalSource(),
+			"http":     httpSource,
+			"https":    httpSource,
+		},
+	}
+	r.sources[hostOf(o.githubRaw)] = httpSource
+	r.sources[hostOf(o.githubAPI)] = &githubSource{httpSource: httpSource}
+
+	registry.RLock()
+	for k, v := range registry.sources {
+		r.sources[k] = v
+	}
+	registry.RUnlock()
+
+	for k, v := range o.sources {
+		r.sources[k] = v
+	}
+	return r
+}
+
+// source returns the source for the location by the host or by the scheme.
+func (r *retriever) source(location string) (Source, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", location)
+	}
+	if u.Scheme != fileScheme {
+		if s, ok := r.sources[strings.ToLower(u.Host)]; ok {
+			return s, nil
+		}
+	}
+	if s, ok := r.sources[strings.ToLower(u.Scheme)]; ok {
+		return s, nil
+	}
+	return nil, errors.Errorf("unsupported source %v", location)
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -17,78 +17,140 @@
 package prefetch
 
 import (
-	"bytes"
-	"text/template"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	appLabel            = "app"
+	binVolumeName       = "bin"
+	returnContainerName = "return"
+	pauseContainerName  = "pause"
+
+	// daemonSetMode prefetches images with DaemonSets that need the return and the pause helper images.
+	daemonSetMode = "daemonset"
+	// jobMode prefetches images with a Job per node that runs a no-op command in each image and ignores the result.
+	jobMode = "job"
 )
 
-func createDaemonSet(number int, containers string) string {
-	const text = `
-cat > prefetch-{{.Number}}.yaml <<EOF
----
-apiVersion: apps/v1
-kind: DaemonSet
-metadata:
-  name: prefetch-{{.Number}}
-  labels:
-    app: prefetch-{{.Number}}
-spec:
-  selector:
-    matchLabels:
-      app: prefetch-{{.Number}}
-  template:
-    metadata:
-      labels:
-        app: prefetch-{{.Number}}
-    spec:
-      initContainers:
-        - name: return
-          image: rrandom312/return
-          imagePullPolicy: IfNotPresent
-          command: ["cp", "/bin/return", "/out/return"]
-          volumeMounts:
-            - name: bin
-              mountPath: /out
-{{.Containers}}
-      containers:
-        - name: pause
-          image: google/pause:latest
-      volumes:
-        - name: bin
-          emptyDir: { }
-EOF
-`
-	return substitute(text, &struct {
-		Number     int
-		Containers string
-	}{
-		Number:     number,
-		Containers: containers,
-	})
+// noopCommand replaces the entrypoint of the images in the job mode. The image is pulled even if the command
+// doesn't exist in it, so the container result doesn't matter.
+var noopCommand = []string{"true"}
+
+func newClient(kubeConfig string) (kubernetes.Interface, error) {
+	if kubeConfig == "" {
+		kubeConfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
 }
 
-func container(name, image string) string {
-	const text = `
-        - name: {{.Name}}
-          image: {{.Image}}
-          imagePullPolicy: IfNotPresent
-          command: ["/bin/return"]
-          volumeMounts:
-            - name: bin
-              mountPath: /bin
-`
-	return substitute(text, &struct {
-		Name, Image string
-	}{
-		Name:  name,
-		Image: image,
-	})
+// podOptions are scheduling, image pull and helper images options of the prefetch pods.
+type podOptions struct {
+	tolerateAll  bool
+	nodeSelector map[string]string
+	pullSecrets  []string
+	returnImage  string
+	pauseImage   string
+}
+
+func (o *podOptions) podSpec() corev1.PodSpec {
+	var result = corev1.PodSpec{NodeSelector: o.nodeSelector}
+	if o.tolerateAll {
+		result.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	}
+	for _, name := range o.pullSecrets {
+		result.ImagePullSecrets = append(result.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+	return result
+}
+
+// daemonSet returns the DaemonSet pulling the images on each node. Each image is pulled by an init container
+// that runs the `return` binary copied by the first init container, so images without shell can be prefetched too.
+func daemonSet(namespace string, number int, images []string, opts *podOptions) *appsv1.DaemonSet {
+	var name = fmt.Sprintf("prefetch-%d", number)
+	var labels = map[string]string{appLabel: name}
+
+	var spec = opts.podSpec()
+	spec.InitContainers = []corev1.Container{{
+		Name:            returnContainerName,
+		Image:           opts.returnImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"cp", "/bin/return", "/out/return"},
+		VolumeMounts:    []corev1.VolumeMount{{Name: binVolumeName, MountPath: "/out"}},
+	}}
+	for i, image := range images {
+		spec.InitContainers = append(spec.InitContainers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"/bin/return"},
+			VolumeMounts:    []corev1.VolumeMount{{Name: binVolumeName, MountPath: "/bin"}},
+		})
+	}
+	spec.Containers = []corev1.Container{{Name: pauseContainerName, Image: opts.pauseImage}}
+	spec.Volumes = []corev1.Volume{{
+		Name:         binVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       spec,
+			},
+		},
+	}
 }
 
-func substitute(text string, data interface{}) string {
-	t, _ := template.New("").Parse(text)
+// job returns the Job pulling the images on the node. Containers of the images run the no-op command in parallel.
+func job(namespace string, number, nodeNumber int, node string, images []string, opts *podOptions) *batchv1.Job {
+	var name = fmt.Sprintf("prefetch-%d-%d", number, nodeNumber)
+	var labels = map[string]string{appLabel: name}
+	var backoffLimit int32
 
-	buf := new(bytes.Buffer)
-	_ = t.Execute(buf, data)
+	var spec = opts.podSpec()
+	spec.NodeName = node
+	spec.RestartPolicy = corev1.RestartPolicyNever
+	for i, image := range images {
+		spec.Containers = append(spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         noopCommand,
+		})
+	}
 
-	return buf.String()
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       spec,
+			},
+		},
+	}
 }
@@ -0,0 +1,314 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const (
+	markerLeaseDuration = 30 * time.Second
+	markerPollInterval  = 2 * time.Second
+	completedKey        = "completed"
+	imagesKey           = "images"
+	nodesKey            = "nodes"
+)
+
+// marker is the in-cluster record of the prefetch of the image list to the node set, shared by test binaries.
+// The prefetch is done by the holder of the marker Lease, other binaries wait until the holder creates the marker
+// ConfigMap or until the Lease expires and take it over.
+type marker struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	holder    string
+	ttl       time.Duration
+	images    []string
+	nodes     []string
+
+	leaseDuration time.Duration
+	pollInterval  time.Duration
+	stopRenew     func()
+}
+
+// newMarker returns the marker of the images prefetch to the schedulable nodes. The marker name is the hash of them.
+func (p *prefetcher) newMarker(ctx context.Context, namespace, holder string, ttl time.Duration, list []string) (*marker, error) {
+	nodes, err := p.schedulableNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var m = &marker{
+		client:        p.client,
+		namespace:     namespace,
+		holder:        holder,
+		ttl:           ttl,
+		images:        append([]string(nil), list...),
+		leaseDuration: markerLeaseDuration,
+		pollInterval:  markerPollInterval,
+	}
+	for i := range nodes {
+		m.nodes = append(m.nodes, nodes[i].Name)
+	}
+	sort.Strings(m.images)
+	sort.Strings(m.nodes)
+
+	var hash = sha256.Sum256([]byte(strings.Join(m.images, "\n") + "\n\n" + strings.Join(m.nodes, "\n")))
+	m.name = namespacePrefix + hex.EncodeToString(hash[:])[:20]
+	return m, nil
+}
+
+// wait returns true if the prefetch is already done. Otherwise waits until the Lease is acquired and returns false,
+// the Lease is renewed until release is called.
+func (m *marker) wait(ctx context.Context) (bool, error) {
+	var logged bool
+	for {
+		done, acquired, holder, err := m.try(ctx)
+		if err != nil || done || acquired {
+			return done, err
+		}
+		if !logged && holder != "" {
+			logrus.Infof("Waiting for the prefetch %v by %v", m.name, holder)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, errors.Wrapf(ctx.Err(), "failed to wait for the prefetch %v", m.name)
+		case <-time.After(m.pollInterval):
+		}
+	}
+}
+
+// try returns true if the prefetch is already done or if the Lease is acquired, the Lease is renewed until release
+// is called. Otherwise returns the current holder of the Lease.
+func (m *marker) try(ctx context.Context) (done, acquired bool, holder string, err error) {
+	if done, err = m.done(ctx); err != nil || done {
+		return done, false, "", err
+	}
+	if acquired, holder, err = m.acquire(ctx); err != nil || !acquired {
+		return false, false, holder, err
+	}
+	// The previous holder could complete the prefetch and delete the Lease after the check above
+	if done, err = m.done(ctx); err != nil || done {
+		if releaseErr := m.release(ctx, false); releaseErr != nil {
+			logrus.Warn(releaseErr.Error())
+		}
+		return done, false, "", err
+	}
+	m.renew()
+	return false, true, holder, nil
+}
+
+// done returns true if the marker ConfigMap exists and is not older than the ttl.
+func (m *marker) done(ctx context.Context) (bool, error) {
+	cm, err := m.client.CoreV1().ConfigMaps(m.namespace).Get(ctx, m.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get prefetch marker %v", m.name)
+	}
+	completed, err := time.Parse(time.RFC3339, cm.Data[completedKey])
+	return err == nil && time.Since(completed) < m.ttl, nil
+}
+
+// collectGarbage deletes marker ConfigMaps completed and Leases expired more than the ttl ago, they are not used
+// anymore. Only objects with the prefetch label are deleted.
+func (m *marker) collectGarbage(ctx context.Context) error {
+	var selector = metav1.ListOptions{LabelSelector: prefetchLabel + "=true"}
+	var configMaps = m.client.CoreV1().ConfigMaps(m.namespace)
+	cms, err := configMaps.List(ctx, selector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list prefetch markers")
+	}
+	var leases = m.client.CoordinationV1().Leases(m.namespace)
+	leaseList, err := leases.List(ctx, selector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list prefetch leases")
+	}
+
+	var errs images.Errors
+	for i := range cms.Items {
+		var cm = &cms.Items[i]
+		completed, err := time.Parse(time.RFC3339, cm.Data[completedKey])
+		if err != nil || time.Since(completed) < m.ttl {
+			continue
+		}
+		logrus.Infof("Deleting prefetch marker %v completed at %v", cm.Name, cm.Data[completedKey])
+		err = configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete prefetch marker %v", cm.Name))
+		}
+	}
+	for i := range leaseList.Items {
+		var lease = &leaseList.Items[i]
+		if lease.Spec.RenewTime != nil && time.Since(lease.Spec.RenewTime.Time) < m.ttl {
+			continue
+		}
+		logrus.Infof("Deleting expired prefetch lease %v", lease.Name)
+		err = leases.Delete(ctx, lease.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete prefetch lease %v", lease.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func markerLabels() map[string]string {
+	return map[string]string{prefetchLabel: "true"}
+}
+
+// acquire creates the Lease or takes it over if it is expired. Returns false and the current holder if the Lease
+// is held by another binary.
+func (m *marker) acquire(ctx context.Context) (acquired bool, holder string, err error) {
+	var leases = m.client.CoordinationV1().Leases(m.namespace)
+	var now = metav1.NewMicroTime(time.Now())
+	var seconds = int32(m.leaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, m.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: m.name, Namespace: m.namespace, Labels: markerLabels()},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", errors.Wrapf(err, "failed to create prefetch lease %v", m.name)
+		}
+		return true, m.holder, nil
+	}
+	if err != nil {
+		return false, "", errors.Wrapf(err, "failed to get prefetch lease %v", m.name)
+	}
+	if lease.Spec.HolderIdentity != nil && !isExpired(lease) {
+		return *lease.Spec.HolderIdentity == m.holder, *lease.Spec.HolderIdentity, nil
+	}
+
+	lease = lease.DeepCopy()
+	var transitions int32 = 1
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions + 1
+	}
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity:       &m.holder,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+		LeaseTransitions:     &transitions,
+	}
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", errors.Wrapf(err, "failed to take over prefetch lease %v", m.name)
+	}
+	logrus.Infof("Prefetch lease %v is expired and taken over", m.name)
+	return true, m.holder, nil
+}
+
+func isExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	var duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return time.Since(lease.Spec.RenewTime.Time) > duration
+}
+
+// renew updates the renew time of the Lease until stopRenew is called.
+func (m *marker) renew() {
+	var ctx, cancel = context.WithCancel(context.Background())
+	m.stopRenew = cancel
+	go func() {
+		var ticker = time.NewTicker(m.leaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			lease, err := m.client.CoordinationV1().Leases(m.namespace).Get(ctx, m.name, metav1.GetOptions{})
+			if err == nil {
+				lease = lease.DeepCopy()
+				var now = metav1.NewMicroTime(time.Now())
+				lease.Spec.RenewTime = &now
+				_, err = m.client.CoordinationV1().Leases(m.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+			}
+			if err != nil && ctx.Err() == nil {
+				logrus.Warnf("Failed to renew prefetch lease %v: %v", m.name, err.Error())
+			}
+		}
+	}()
+}
+
+// release stops renewing the Lease, creates the marker ConfigMap if the prefetch is completed and deletes the Lease.
+func (m *marker) release(ctx context.Context, completed bool) error {
+	if m.stopRenew != nil {
+		m.stopRenew()
+	}
+
+	if completed {
+		var cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: m.name, Namespace: m.namespace, Labels: markerLabels()},
+			Data: map[string]string{
+				completedKey: time.Now().UTC().Format(time.RFC3339),
+				imagesKey:    strings.Join(m.images, "\n"),
+				nodesKey:     strings.Join(m.nodes, "\n"),
+			},
+		}
+		_, err := m.client.CoreV1().ConfigMaps(m.namespace).Create(ctx, cm, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = m.client.CoreV1().ConfigMaps(m.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to create prefetch marker %v", m.name)
+		}
+	}
+
+	err := m.client.CoordinationV1().Leases(m.namespace).Delete(ctx, m.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete prefetch lease %v", m.name)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestMarker(t *testing.T, client kubernetes.Interface, holder string, list ...string) *marker {
+	var p = &prefetcher{client: client}
+	m, err := p.newMarker(context.Background(), "default", holder, time.Hour, list)
+	require.NoError(t, err)
+	m.leaseDuration = time.Second * 3
+	m.pollInterval = time.Millisecond * 10
+	return m
+}
+
+func TestMarker(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}), node("b", corev1.NodeSpec{}))
+	var first = newTestMarker(t, client, "first", "nginx", "alpine")
+	var second = newTestMarker(t, client, "second", "alpine", "nginx")
+	require.Equal(t, first.name, second.name)
+	require.NotEqual(t, first.name, newTestMarker(t, client, "third", "alpine").name)
+
+	done, err := first.wait(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+
+	var result = make(chan bool, 1)
+	go func() {
+		done, err := second.wait(context.Background())
+		result <- done && err == nil
+	}()
+	select {
+	case <-result:
+		require.FailNow(t, "the lease is held by the first binary")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	require.NoError(t, first.release(context.Background(), true))
+	require.True(t, <-result)
+
+	_, err = client.CoordinationV1().Leases("default").Get(context.Background(), first.name, metav1.GetOptions{})
+	require.Error(t, err)
+}
+
+func TestMarker_Failed(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var first = newTestMarker(t, client, "first", "alpine")
+	var second = newTestMarker(t, client, "second", "alpine")
+
+	done, err := first.wait(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+	require.NoError(t, first.release(context.Background(), false))
+
+	done, err = second.wait(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+	require.NoError(t, second.release(context.Background(), false))
+}
+
+func TestMarker_ExpiredLease(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var m = newTestMarker(t, client, "alive", "alpine")
+
+	var holder, seconds = "crashed", int32(30)
+	var renewed = metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	_, err := client.CoordinationV1().Leases("default").Create(context.Background(), &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: m.name, Namespace: "default"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &seconds, RenewTime: &renewed},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done, err := m.wait(ctx)
+	require.NoError(t, err)
+	require.False(t, done)
+	defer func() { _ = m.release(context.Background(), false) }()
+
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), m.name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "alive", *lease.Spec.HolderIdentity)
+	require.Equal(t, int32(1), *lease.Spec.LeaseTransitions)
+}
+
+func TestMarker_Expired(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var m = newTestMarker(t, client, "first", "alpine")
+
+	_, err := client.CoreV1().ConfigMaps("default").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: m.name, Namespace: "default"},
+		Data:       map[string]string{completedKey: time.Now().Add(-2 * time.Hour).Format(time.RFC3339)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	done, err := m.done(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+
+	require.NoError(t, m.release(context.Background(), true))
+	done, err = m.done(context.Background())
+	require.NoError(t, err)
+	require.True(t, done)
+}
+
+func TestMarker_DoneAfterAcquire(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var m = newTestMarker(t, client, "second", "alpine")
+
+	// The first binary completes the prefetch right after the second one checks the marker
+	var completed bool
+	client.PrependReactor("get", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		completed = true
+		return false, nil, nil
+	})
+	client.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if !completed {
+			return false, nil, nil
+		}
+		return true, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: m.name, Namespace: "default"},
+			Data:       map[string]string{completedKey: time.Now().UTC().Format(time.RFC3339)},
+		}, nil
+	})
+
+	done, err := m.wait(context.Background())
+	require.NoError(t, err)
+	require.True(t, done)
+
+	_, err = client.CoordinationV1().Leases("default").Get(context.Background(), m.name, metav1.GetOptions{})
+	require.Error(t, err)
+}
+
+func TestMarker_CollectGarbage(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var m = newTestMarker(t, client, "first", "alpine")
+
+	for name, cm := range map[string]struct {
+		completed time.Time
+		labels    map[string]string
+	}{
+		namespacePrefix + "expired": {completed: time.Now().Add(-2 * time.Hour), labels: markerLabels()},
+		namespacePrefix + "fresh":   {completed: time.Now(), labels: markerLabels()},
+		namespacePrefix + "foreign": {completed: time.Now().Add(-2 * time.Hour)},
+	} {
+		_, err := client.CoreV1().ConfigMaps("default").Create(context.Background(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: cm.labels},
+			Data:       map[string]string{completedKey: cm.completed.UTC().Format(time.RFC3339)},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	var renewTime = metav1.NewMicroTime(time.Now().Add(-2 * time.Hour))
+	for name, labels := range map[string]map[string]string{
+		namespacePrefix + "stale":   markerLabels(),
+		namespacePrefix + "foreign": nil,
+	} {
+		_, err := client.CoordinationV1().Leases("default").Create(context.Background(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       coordinationv1.LeaseSpec{RenewTime: &renewTime},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, m.collectGarbage(context.Background()))
+
+	configMaps, err := client.CoreV1().ConfigMaps("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for i := range configMaps.Items {
+		names = append(names, configMaps.Items[i].Name)
+	}
+	require.ElementsMatch(t, []string{namespacePrefix + "fresh", namespacePrefix + "foreign"}, names)
+
+	leases, err := client.CoordinationV1().Leases("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, leases.Items, 1)
+	require.Equal(t, namespacePrefix+"foreign", leases.Items[0].Name)
+}
+
+func TestMarker_Labels(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var m = newTestMarker(t, client, "first", "alpine")
+
+	done, err := m.wait(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), m.name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, markerLabels(), lease.Labels)
+
+	require.NoError(t, m.release(context.Background(), true))
+	cm, err := client.CoreV1().ConfigMaps("default").Get(context.Background(), m.name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, markerLabels(), cm.Labels)
+}
+
+func TestMarker_TryHeld(t *testing.T) {
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}))
+	var first = newTestMarker(t, client, "first", "alpine")
+	var second = newTestMarker(t, client, "second", "alpine")
+
+	done, err := first.wait(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+	defer func() { _ = first.release(context.Background(), false) }()
+
+	done, acquired, holder, err := second.try(context.Background())
+	require.NoError(t, err)
+	require.False(t, done)
+	require.False(t, acquired)
+	require.Equal(t, "first", holder)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+// missingImages returns images that are not present at least on one schedulable node according to node.Status.Images.
+func (p *prefetcher) missingImages(ctx context.Context, list []string) ([]string, error) {
+	nodes, err := p.schedulableNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var present []map[string]bool
+	for i := range nodes {
+		present = append(present, nodeImages(&nodes[i]))
+	}
+	if len(present) == 0 {
+		return list, nil
+	}
+
+	var result []string
+	for _, image := range list {
+		var keys = imageKeys(image)
+		for _, nodeImages := range present {
+			if !containsAny(nodeImages, keys) {
+				result = append(result, image)
+				break
+			}
+		}
+	}
+
+	logrus.Infof("%v images are present on all %v nodes, %v images are missing", len(list)-len(result), len(present), len(result))
+	return result, nil
+}
+
+// schedulableNodes returns nodes the prefetch pods can be scheduled to.
+func (p *prefetcher) schedulableNodes(ctx context.Context) ([]corev1.Node, error) {
+	nodes, err := p.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+	var result []corev1.Node
+	for i := range nodes.Items {
+		if isSchedulable(&nodes.Items[i], &p.pod) {
+			result = append(result, nodes.Items[i])
+		}
+	}
+	return result, nil
+}
+
+// isSchedulable returns true if the prefetch pods can be scheduled to the node.
+func isSchedulable(node *corev1.Node, opts *podOptions) bool {
+	if node.Spec.Unschedulable && !opts.tolerateAll {
+		return false
+	}
+	for key, value := range opts.nodeSelector {
+		if node.Labels[key] != value {
+			return false
+		}
+	}
+	if opts.tolerateAll {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeImages(node *corev1.Node) map[string]bool {
+	var result = map[string]bool{}
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			for _, key := range imageKeys(name) {
+				result[key] = true
+			}
+		}
+	}
+	return result
+}
+
+// imageKeys returns normalized forms of the image the node can report it by: with the tag and with the digest.
+func imageKeys(image string) []string {
+	ref, err := images.ParseReference(image)
+	if err != nil {
+		return []string{image}
+	}
+	var result = []string{ref.String()}
+	if ref.Digest != "" && ref.Tag != "" {
+		result = append(result, ref.Name()+"@"+ref.Digest)
+	}
+	return result
+}
+
+func containsAny(set map[string]bool, keys []string) bool {
+	for _, key := range keys {
+		if set[key] {
+			return true
+		}
+	}
+	return false
+}
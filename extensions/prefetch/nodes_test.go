@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func node(name string, spec corev1.NodeSpec, names ...string) *corev1.Node {
+	var result = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+	for _, n := range names {
+		result.Status.Images = append(result.Status.Images, corev1.ContainerImage{Names: []string{n}})
+	}
+	return result
+}
+
+func TestMissingImages(t *testing.T) {
+	const digest = "sha256:4edbd2beb5f78b1014028f4fbb99f3237d9561100b6881aabbf5acce2c4f9454"
+
+	var client = fake.NewSimpleClientset(
+		node("a", corev1.NodeSpec{},
+			"docker.io/library/alpine:latest",
+			"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+			"docker.io/library/nginx@"+digest,
+		),
+		node("b", corev1.NodeSpec{},
+			"docker.io/library/alpine:latest",
+			"docker.io/library/nginx@"+digest,
+		),
+		node("control-plane", corev1.NodeSpec{Taints: []corev1.Taint{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}}}),
+		node("cordoned", corev1.NodeSpec{Unschedulable: true}),
+	)
+
+	var p = &prefetcher{client: client, namespace: testNamespace}
+	missing, err := p.missingImages(context.Background(), []string{
+		"alpine",
+		"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0",
+		"nginx:1.21@" + digest,
+		"busybox",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.0.0", "busybox"}, missing)
+}
+
+func TestMissingImages_NoNodes(t *testing.T) {
+	var p = &prefetcher{client: fake.NewSimpleClientset(), namespace: testNamespace}
+	missing, err := p.missingImages(context.Background(), []string{"alpine"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpine"}, missing)
+}
+
+func TestMissingImages_PodOptions(t *testing.T) {
+	var worker = node("worker", corev1.NodeSpec{}, "docker.io/library/alpine:latest")
+	worker.Labels = map[string]string{"kubernetes.io/hostname": "worker"}
+	var client = fake.NewSimpleClientset(
+		worker,
+		node("control-plane", corev1.NodeSpec{Taints: []corev1.Taint{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}}}),
+	)
+
+	var p = &prefetcher{client: client, namespace: testNamespace, pod: podOptions{tolerateAll: true}}
+	missing, err := p.missingImages(context.Background(), []string{"alpine"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"alpine"}, missing)
+
+	p.pod.nodeSelector = worker.Labels
+	missing, err = p.missingImages(context.Background(), []string{"alpine"})
+	require.NoError(t, err)
+	require.Empty(t, missing)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const sizeConcurrency = 8
+
+// chunkImages splits the images into DaemonSets of imagesPerDaemonSet images in the passed order.
+func chunkImages(list []string, imagesPerDaemonSet int) [][]string {
+	var result [][]string
+	for len(list) > 0 {
+		var n = imagesPerDaemonSet
+		if n > len(list) {
+			n = len(list)
+		}
+		result = append(result, list[:n])
+		list = list[n:]
+	}
+	return result
+}
+
+// packImages packs the images into DaemonSets of at most budget bytes and imagesPerDaemonSet images with
+// the first fit decreasing algorithm, so the largest images are in the first DaemonSets. Images larger than
+// the budget get DaemonSets of their own. Images of unknown size are assumed to have the average known size.
+func packImages(list []string, sizes map[string]int64, budget int64, imagesPerDaemonSet int) [][]string {
+	var average int64
+	if len(sizes) > 0 {
+		for _, size := range sizes {
+			average += size
+		}
+		average /= int64(len(sizes))
+	}
+	var sizeOf = func(image string) int64 {
+		if size, ok := sizes[image]; ok {
+			return size
+		}
+		return average
+	}
+
+	var sorted = append([]string(nil), list...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sizeOf(sorted[i]) > sizeOf(sorted[j])
+	})
+
+	type bin struct {
+		images []string
+		size   int64
+	}
+	var bins []*bin
+	for _, image := range sorted {
+		var size = sizeOf(image)
+		var target *bin
+		for _, b := range bins {
+			if b.size+size <= budget && len(b.images) < imagesPerDaemonSet {
+				target = b
+				break
+			}
+		}
+		if target == nil {
+			target = new(bin)
+			bins = append(bins, target)
+		}
+		target.images = append(target.images, image)
+		target.size += size
+	}
+
+	var result [][]string
+	for _, b := range bins {
+		result = append(result, b.images)
+	}
+	return result
+}
+
+// sizes returns compressed sizes of the images. Images which size cannot be read are logged and skipped.
+func (c *registryClient) sizes(ctx context.Context, list []string) map[string]int64 {
+	var result = map[string]int64{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, sizeConcurrency)
+	for _, image := range list {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(image string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			size, err := c.size(ctx, image)
+			if err != nil {
+				logrus.Warnf("Size of the image %v is unknown: %v", image, err.Error())
+				return
+			}
+			mu.Lock()
+			result[image] = size
+			mu.Unlock()
+		}(image)
+	}
+	wg.Wait()
+	return result
+}
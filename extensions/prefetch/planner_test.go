@@ -0,0 +1,140 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	amd64Digest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	arm64Digest = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	listDigest  = "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+)
+
+// newRegistry starts the registry stand-in, org/app requires the token and has the manifest list.
+// Returns the registry host and the counter of the served manifests.
+func newRegistry(t *testing.T) (string, *int32) {
+	var gets int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.URL.Path {
+		case "/token":
+			require.Equal(t, "repository:org/app:pull", r.URL.Query().Get("scope"))
+			body = map[string]string{"token": "secret"}
+		case "/v2/org/app/manifests/v1", "/v2/org/app/manifests/" + listDigest, "/v2/org/app/manifests/" + amd64Digest:
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:org/app:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !strings.HasSuffix(r.URL.Path, amd64Digest) {
+				w.Header().Set("Docker-Content-Digest", listDigest)
+				body = map[string]interface{}{"manifests": []map[string]interface{}{
+					{"digest": arm64Digest, "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+					{"digest": amd64Digest, "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+				}}
+			} else {
+				body = map[string]interface{}{
+					"config": map[string]interface{}{"size": 100},
+					"layers": []map[string]interface{}{{"size": 1000}, {"size": 2000}},
+				}
+			}
+		case "/v2/org/small/manifests/latest":
+			body = map[string]interface{}{
+				"config": map[string]interface{}{"size": 10},
+				"layers": []map[string]interface{}{{"size": 90}},
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/manifests/") {
+			atomic.AddInt32(&gets, 1)
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://"), &gets
+}
+
+func TestRegistryClient_Sizes(t *testing.T) {
+	var registry, _ = newRegistry(t)
+	var c = &registryClient{client: http.DefaultClient, platform: "linux/amd64"}
+
+	require.Equal(t, map[string]int64{
+		registry + "/org/app:v1": 3100,
+		registry + "/org/small":  100,
+	}, c.sizes(context.Background(), []string{
+		registry + "/org/app:v1",
+		registry + "/org/small",
+		registry + "/org/missing:v1",
+	}))
+
+	c.platform = "windows/amd64"
+	_, err := c.size(context.Background(), registry+"/org/app:v1")
+	require.Error(t, err)
+}
+
+func TestRegistryClient_CachedSize(t *testing.T) {
+	var registry, gets = newRegistry(t)
+	var c = &registryClient{client: http.DefaultClient, platform: "linux/amd64", cacheDir: t.TempDir()}
+
+	size, err := c.size(context.Background(), registry+"/org/app:v1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3100), size)
+	require.Equal(t, int32(2), atomic.LoadInt32(gets))
+
+	size, err = c.size(context.Background(), registry+"/org/app:v1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3100), size)
+	require.Equal(t, int32(2), atomic.LoadInt32(gets))
+
+	// The size of another platform is not cached
+	c.platform = "linux/arm64"
+	_, err = c.size(context.Background(), registry+"/org/app:v1")
+	require.Error(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(gets))
+}
+
+func TestPackImages(t *testing.T) {
+	var sizes = map[string]int64{"a": 900, "b": 600, "c": 500, "d": 300, "e": 100, "huge": 5000}
+
+	require.Equal(t, [][]string{{"a", "e"}, {"b", "d"}, {"c"}},
+		packImages([]string{"e", "d", "c", "b", "a"}, sizes, 1000, 10))
+	require.Equal(t, [][]string{{"huge"}, {"a", "e"}, {"b", "d"}, {"c"}},
+		packImages([]string{"e", "d", "c", "b", "a", "huge"}, sizes, 1000, 2))
+	require.Equal(t, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}},
+		packImages([]string{"e", "d", "c", "b", "a"}, sizes, 1000, 1))
+	// unknown is assumed to be of the average size
+	require.Equal(t, [][]string{{"a"}, {"unknown", "b"}},
+		packImages([]string{"unknown", "b", "a"}, map[string]int64{"a": 900, "b": 100}, 600, 10))
+}
+
+func TestChunkImages(t *testing.T) {
+	require.Equal(t, [][]string{{"a", "b"}, {"c"}}, chunkImages([]string{"a", "b", "c"}, 2))
+	require.Empty(t, chunkImages(nil, 2))
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const diagnoseTimeout = 30 * time.Second
+
+// prefetcher creates prefetch DaemonSets and waits until their pods pull the images on all nodes.
+type prefetcher struct {
+	client    kubernetes.Interface
+	namespace string
+	// labels are the labels of the namespace.
+	labels  map[string]string
+	timeout time.Duration
+	mode    string
+	pod     podOptions
+	// stats collects pull statistics, nil disables them.
+	stats *pullStats
+}
+
+func (p *prefetcher) createNamespace(ctx context.Context) error {
+	_, err := p.client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: p.namespace, Labels: p.labels},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create namespace %v", p.namespace)
+	}
+	return nil
+}
+
+func (p *prefetcher) deleteNamespace(ctx context.Context) error {
+	err := p.client.CoreV1().Namespaces().Delete(ctx, p.namespace, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete namespace %v", p.namespace)
+	}
+	return nil
+}
+
+// copyPullSecrets copies the secrets to the prefetch namespace and uses them to pull images.
+// Secrets are referenced by `name` in the sourceNamespace or by `namespace/name`.
+func (p *prefetcher) copyPullSecrets(ctx context.Context, sourceNamespace string, secrets []string) error {
+	for _, secret := range secrets {
+		var ns, name = sourceNamespace, secret
+		if i := strings.Index(secret, "/"); i >= 0 {
+			ns, name = secret[:i], secret[i+1:]
+		}
+
+		source, err := p.client.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get pull secret %v/%v", ns, name)
+		}
+		var target = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: p.namespace},
+			Type:       source.Type,
+			Data:       source.Data,
+		}
+		_, err = p.client.CoreV1().Secrets(p.namespace).Create(ctx, target, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = p.client.CoreV1().Secrets(p.namespace).Update(ctx, target, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy pull secret %v/%v", ns, name)
+		}
+		p.pod.pullSecrets = append(p.pod.pullSecrets, name)
+	}
+	return nil
+}
+
+// prefetch runs workloads of the plan concurrently: a DaemonSet per group of images or, in the job mode,
+// a Job per group of images and node.
+func (p *prefetcher) prefetch(ctx context.Context, plan [][]string) error {
+	groups, err := p.workloads(ctx, plan)
+	if err != nil {
+		return err
+	}
+	var runs []func() error
+	for _, group := range groups {
+		runs = append(runs, group...)
+	}
+	return runAll(runs)
+}
+
+// workloads returns functions running the workloads of each group of the plan.
+func (p *prefetcher) workloads(ctx context.Context, plan [][]string) ([][]func() error, error) {
+	var result = make([][]func() error, len(plan))
+	switch p.mode {
+	case jobMode:
+		nodes, err := p.schedulableNodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i, group := range plan {
+			for n := range nodes {
+				var j = job(p.namespace, i, n, nodes[n].Name, group, &p.pod)
+				result[i] = append(result[i], func() error { return p.runJob(ctx, j) })
+			}
+		}
+	default:
+		for i, group := range plan {
+			var ds = daemonSet(p.namespace, i, group, &p.pod)
+			result[i] = append(result[i], func() error { return p.runDaemonSet(ctx, ds) })
+		}
+	}
+	return result, nil
+}
+
+// runAll calls the functions concurrently and returns their errors.
+func runAll(runs []func() error) error {
+	var mu sync.Mutex
+	var errs images.Errors
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run func() error) {
+			defer wg.Done()
+			if err := run(); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(run)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// runDaemonSet creates the DaemonSet, waits until it is ready and deletes it.
+func (p *prefetcher) runDaemonSet(ctx context.Context, ds *appsv1.DaemonSet) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var daemonSets = p.client.AppsV1().DaemonSets(p.namespace)
+	if _, err := daemonSets.Create(ctx, ds, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to create daemonset %v", ds.Name)
+	}
+	defer func() {
+		if err := daemonSets.Delete(context.Background(), ds.Name, metav1.DeleteOptions{}); err != nil {
+			logrus.Warnf("Failed to delete daemonset %v: %v", ds.Name, err.Error())
+		}
+	}()
+
+	return p.waitPods(ctx, "daemonset "+ds.Name, ds.Spec.Selector.MatchLabels, func(pods map[string]*corev1.Pod) (bool, error) {
+		return p.isReady(ctx, ds, pods)
+	})
+}
+
+// runJob creates the Job, waits until its pod pulls all images and deletes it.
+func (p *prefetcher) runJob(ctx context.Context, j *batchv1.Job) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var jobs = p.client.BatchV1().Jobs(p.namespace)
+	if _, err := jobs.Create(ctx, j, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to create job %v", j.Name)
+	}
+	defer func() {
+		var propagation = metav1.DeletePropagationBackground
+		if err := jobs.Delete(context.Background(), j.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			logrus.Warnf("Failed to delete job %v: %v", j.Name, err.Error())
+		}
+	}()
+
+	return p.waitPods(ctx, "job "+j.Name, j.Spec.Template.Labels, func(pods map[string]*corev1.Pod) (bool, error) {
+		for _, pod := range pods {
+			if isPodPulled(pod) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// waitPods watches pods with the labels until they are done.
+func (p *prefetcher) waitPods(ctx context.Context, name string, podLabels map[string]string, done func(pods map[string]*corev1.Pod) (bool, error)) error {
+	var opts = metav1.ListOptions{LabelSelector: labels.SelectorFromSet(podLabels).String()}
+	var podsClient = p.client.CoreV1().Pods(p.namespace)
+
+	w, err := podsClient.Watch(ctx, opts)
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch pods of %v", name)
+	}
+	defer func() { w.Stop() }()
+
+	list, err := podsClient.List(ctx, opts)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list pods of %v", name)
+	}
+	var pods = map[string]*corev1.Pod{}
+	for i := range list.Items {
+		pods[list.Items[i].Name] = &list.Items[i]
+	}
+	defer func() { p.recordStats(name, pods) }()
+
+	for {
+		ready, err := done(pods)
+		if err != nil || ready {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return p.notReadyError(name, pods)
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				if w, err = podsClient.Watch(ctx, opts); err != nil {
+					return errors.Wrapf(err, "failed to watch pods of %v", name)
+				}
+				continue
+			}
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				if event.Type == watch.Deleted {
+					delete(pods, pod.Name)
+				} else {
+					pods[pod.Name] = pod
+				}
+				if hasInvalidImage(pod) {
+					return p.notReadyError(name, pods)
+				}
+			}
+		}
+	}
+}
+
+func (p *prefetcher) isReady(ctx context.Context, ds *appsv1.DaemonSet, pods map[string]*corev1.Pod) (bool, error) {
+	current, err := p.client.AppsV1().DaemonSets(p.namespace).Get(ctx, ds.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get daemonset %v", ds.Name)
+	}
+	var desired = current.Status.DesiredNumberScheduled
+	if desired == 0 {
+		return false, nil
+	}
+
+	var ready int32
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			ready++
+		}
+	}
+	return ready >= desired, nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isPodPulled returns true if all containers of the pod have been started, so their images are pulled.
+func isPodPulled(pod *corev1.Pod) bool {
+	var started int
+	for i := range pod.Status.ContainerStatuses {
+		var state = pod.Status.ContainerStatuses[i].State
+		if state.Running != nil || state.Terminated != nil {
+			started++
+		}
+	}
+	return started == len(pod.Spec.Containers)
+}
+
+// notReadyError logs the image × node statuses of the pods and returns the error naming the images that are not pulled.
+func (p *prefetcher) notReadyError(name string, pods map[string]*corev1.Pod) error {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+
+	var statuses = p.diagnose(ctx, pods)
+	var failed, pending = brokenImages(statuses)
+	if len(failed) == 0 && len(pending) == 0 {
+		return errors.Errorf("%v is not ready: %v pods are not ready", name, len(pods))
+	}
+	logrus.Errorf("Prefetch %v is not ready:\n%v", name, formatStatuses(statuses))
+
+	var reasons []string
+	if len(failed) > 0 {
+		reasons = append(reasons, "images are not prefetched: "+strings.Join(failed, ", "))
+	}
+	if len(pending) > 0 {
+		reasons = append(reasons, "images are still pending: "+strings.Join(pending, ", "))
+	}
+	return errors.Errorf("%v is not ready, %v", name, strings.Join(reasons, "; "))
+}
+
+// hasInvalidImage returns true if the pod has the image that will never be pulled.
+func hasInvalidImage(pod *corev1.Pod) bool {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			if waiting := statuses[i].State.Waiting; waiting != nil && waiting.Reason == invalidImageNameReason {
+				return true
+			}
+		}
+	}
+	return false
+}
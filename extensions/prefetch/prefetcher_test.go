@@ -0,0 +1,227 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = namespacePrefix + "test"
+
+// runController emulates the DaemonSet controller scheduling each DaemonSet to a single node.
+func runController(ctx context.Context, t *testing.T, client kubernetes.Interface, status corev1.PodStatus) {
+	w, err := client.AppsV1().DaemonSets(testNamespace).Watch(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+
+	go func() {
+		defer w.Stop()
+		for event := range w.ResultChan() {
+			ds, ok := event.Object.(*appsv1.DaemonSet)
+			if !ok || event.Type != watch.Added {
+				continue
+			}
+			ds = ds.DeepCopy()
+			ds.Status.DesiredNumberScheduled = 1
+			_, _ = client.AppsV1().DaemonSets(testNamespace).UpdateStatus(ctx, ds, metav1.UpdateOptions{})
+			_, _ = client.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: ds.Name + "-node", Namespace: testNamespace, Labels: ds.Spec.Selector.MatchLabels},
+				Spec:       ds.Spec.Template.Spec,
+				Status:     status,
+			}, metav1.CreateOptions{})
+		}
+	}()
+}
+
+func TestPrefetcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client = fake.NewSimpleClientset()
+	runController(ctx, t, client, corev1.PodStatus{
+		Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+	})
+
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Second * 5}
+	require.NoError(t, p.createNamespace(ctx))
+	require.NoError(t, p.createNamespace(ctx))
+	require.NoError(t, p.prefetch(ctx, chunkImages([]string{"alpine", "nginx", "busybox"}, 2)))
+
+	list, err := client.AppsV1().DaemonSets(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, list.Items)
+
+	require.NoError(t, p.deleteNamespace(ctx))
+	require.NoError(t, p.deleteNamespace(ctx))
+}
+
+func TestPrefetcher_Timeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client = fake.NewSimpleClientset()
+	runController(ctx, t, client, corev1.PodStatus{
+		InitContainerStatuses: []corev1.ContainerStatus{
+			{
+				Name:  "image-0",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+			},
+			{
+				Name:  "image-1",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			},
+		},
+	})
+	_, err := client.CoreV1().Events(testNamespace).Create(ctx, &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "failed", Namespace: testNamespace},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "prefetch-0-node"},
+		Type:           corev1.EventTypeWarning,
+		Reason:         "Failed",
+		Message:        `Failed to pull image "ghcr.io/networkservicemesh/missing": manifest unknown`,
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Millisecond * 200}
+	err = p.prefetch(ctx, [][]string{{"alpine", "ghcr.io/networkservicemesh/missing"}})
+	require.Error(t, err)
+	require.Equal(t, "daemonset prefetch-0 is not ready, images are not prefetched: ghcr.io/networkservicemesh/missing", err.Error())
+}
+
+func TestPrefetcher_InvalidImageName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client = fake.NewSimpleClientset()
+	runController(ctx, t, client, corev1.PodStatus{
+		InitContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "image-0",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: invalidImageNameReason}},
+		}},
+	})
+
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Minute}
+	err := p.prefetch(ctx, [][]string{{"Invalid"}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "images are not prefetched: Invalid")
+}
+
+func TestDaemonSet(t *testing.T) {
+	var ds = daemonSet(testNamespace, 1, []string{"alpine", "nginx"}, &podOptions{returnImage: "return", pauseImage: "pause"})
+	require.Equal(t, "prefetch-1", ds.Name)
+	require.Equal(t, ds.Spec.Selector.MatchLabels, ds.Spec.Template.Labels)
+
+	var initContainers = ds.Spec.Template.Spec.InitContainers
+	require.Len(t, initContainers, 3)
+	require.Equal(t, "return", initContainers[0].Image)
+	require.Equal(t, "alpine", initContainers[1].Image)
+	require.Equal(t, "nginx", initContainers[2].Image)
+	require.Equal(t, "pause", ds.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestDaemonSet_PodOptions(t *testing.T) {
+	var spec = daemonSet(testNamespace, 0, []string{"alpine"}, &podOptions{
+		tolerateAll:  true,
+		nodeSelector: map[string]string{"sriov": "true"},
+		pullSecrets:  []string{"regcred"},
+	}).Spec.Template.Spec
+
+	require.Equal(t, []corev1.Toleration{{Operator: corev1.TolerationOpExists}}, spec.Tolerations)
+	require.Equal(t, map[string]string{"sriov": "true"}, spec.NodeSelector)
+	require.Equal(t, []corev1.LocalObjectReference{{Name: "regcred"}}, spec.ImagePullSecrets)
+}
+
+func TestPrefetcher_CopyPullSecrets(t *testing.T) {
+	var ctx = context.Background()
+	var secret = func(ns, name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		}
+	}
+	var client = fake.NewSimpleClientset(secret("default", "regcred"), secret("ci", "ghcr"), secret(testNamespace, "ghcr"))
+
+	var p = &prefetcher{client: client, namespace: testNamespace}
+	require.NoError(t, p.copyPullSecrets(ctx, "default", []string{"regcred", "ci/ghcr"}))
+	require.Equal(t, []string{"regcred", "ghcr"}, p.pod.pullSecrets)
+
+	copied, err := client.CoreV1().Secrets(testNamespace).Get(ctx, "regcred", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, corev1.SecretTypeDockerConfigJson, copied.Type)
+
+	require.Error(t, p.copyPullSecrets(ctx, "default", []string{"missing"}))
+}
+
+func TestPrefetcher_JobMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var client = fake.NewSimpleClientset(node("a", corev1.NodeSpec{}), node("b", corev1.NodeSpec{}))
+	w, err := client.BatchV1().Jobs(testNamespace).Watch(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	defer w.Stop()
+
+	var jobs = make(chan *batchv1.Job, 10)
+	go func() {
+		for event := range w.ResultChan() {
+			j, ok := event.Object.(*batchv1.Job)
+			if !ok || event.Type != watch.Added {
+				continue
+			}
+			jobs <- j
+			// The command doesn't exist in the image, but the image is pulled
+			var statuses []corev1.ContainerStatus
+			for _, c := range j.Spec.Template.Spec.Containers {
+				statuses = append(statuses, corev1.ContainerStatus{
+					Name:  c.Name,
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 128, Reason: "StartError"}},
+				})
+			}
+			_, _ = client.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: j.Name + "-pod", Namespace: testNamespace, Labels: j.Spec.Template.Labels},
+				Spec:       j.Spec.Template.Spec,
+				Status:     corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: statuses},
+			}, metav1.CreateOptions{})
+		}
+	}()
+
+	var p = &prefetcher{client: client, namespace: testNamespace, timeout: time.Second * 5, mode: jobMode}
+	require.NoError(t, p.prefetch(ctx, [][]string{{"alpine", "nginx"}}))
+
+	var nodes []string
+	for i := 0; i < 2; i++ {
+		var j = <-jobs
+		require.Nil(t, j.Spec.Template.Spec.InitContainers)
+		require.Equal(t, noopCommand, j.Spec.Template.Spec.Containers[0].Command)
+		nodes = append(nodes, j.Spec.Template.Spec.NodeName)
+	}
+	require.ElementsMatch(t, []string{"a", "b"}, nodes)
+
+	list, err := client.BatchV1().Jobs(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, list.Items)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+// DefaultProfile is the profile used if no profile is selected.
+const DefaultProfile = "default"
+
+// profile is a named set of image sources and exclusions for a feature area.
+type profile struct {
+	// paths are sources relative to the repository root. Files are read as is, directories are listed recursively.
+	paths []string
+	// exclude is the exclude rule used if PREFETCH_EXCLUDE is not set.
+	exclude string
+}
+
+// Feature profiles search only the examples of the feature, apps used by the examples are found by rendering their
+// kustomizations.
+var profiles = map[string]*profile{
+	DefaultProfile: {
+		paths:   []string{images.ExternalImagesPath, images.AppsPath},
+		exclude: images.DefaultExclude,
+	},
+	// SR-IOV kernel examples without the VFIO ones
+	"sriov": {
+		paths:   []string{images.ExternalImagesPath, "examples/sriov"},
+		exclude: "path:(?i)vfio",
+	},
+	// VFIO examples use the SR-IOV forwarder of the SR-IOV setup, but not the SR-IOV kernel examples and apps
+	"vfio": {
+		paths:   []string{images.ExternalImagesPath, "examples/sriov"},
+		exclude: "path:(?i)kernel",
+	},
+	"interdomain": {
+		paths:   []string{images.ExternalImagesPath, "examples/interdomain"},
+		exclude: images.DefaultExclude,
+	},
+	// Heal examples run on the basic setup
+	"heal": {
+		paths:   []string{images.ExternalImagesPath, "examples/basic", "examples/heal"},
+		exclude: images.DefaultExclude,
+	},
+	"all": {
+		paths: []string{images.ExternalImagesPath, images.AppsPath, "examples"},
+	},
+}
+
+func getProfile(name string) (*profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+	var names []string
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, errors.Errorf("unknown prefetch profile %q, expected one of: %v", name, strings.Join(names, ", "))
+}
+
+// sourcesURLs returns URLs of the profile sources in the github repository of the version.
+func (p *profile) sourcesURLs(repository, version string) []string {
+	return images.GithubSourcesURLs(repository, version, p.paths...)
+}
+
+// localSourcesURLs returns URLs of the profile sources in the local directory of the repository.
+func (p *profile) localSourcesURLs(dir string) []string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	var result []string
+	for _, path := range p.paths {
+		result = append(result, "file://"+filepath.Join(dir, filepath.FromSlash(path)))
+	}
+	return result
+}
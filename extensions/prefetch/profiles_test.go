@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+func TestGetProfile(t *testing.T) {
+	p, err := getProfile("")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://raw.githubusercontent.com/org/repo/v1.0.0/external-images.yaml",
+		"https://api.github.com/repos/org/repo/contents/apps?ref=v1.0.0",
+	}, p.sourcesURLs("org/repo", "v1.0.0"))
+	require.Equal(t, images.DefaultExclude, p.exclude)
+
+	p, err = getProfile("sriov")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://raw.githubusercontent.com/org/repo/v1.0.0/external-images.yaml",
+		"https://api.github.com/repos/org/repo/contents/examples/sriov?ref=v1.0.0",
+	}, p.sourcesURLs("org/repo", "v1.0.0"))
+
+	_, err = getProfile("unknown")
+	require.Error(t, err)
+}
+
+func TestProfiles(t *testing.T) {
+	for name, p := range profiles {
+		if name != DefaultProfile && name != "all" {
+			require.NotContains(t, p.paths, images.AppsPath, name)
+		}
+		for other, o := range profiles {
+			if name != other {
+				require.NotEqual(t, o, p, "%v and %v", name, other)
+			}
+		}
+	}
+
+	for name, expected := range map[string]map[string]bool{
+		DefaultProfile: {"apps/nsc-kernel": true, "apps/forwarder-sriov": false, "apps/nse-vfio": false},
+		"sriov":        {"examples/sriov/SriovKernel2Noop": true, "examples/sriov/Vfio2Noop": false, "apps/forwarder-sriov": true},
+		"vfio":         {"examples/sriov/SriovKernel2Noop": false, "examples/sriov/Vfio2Noop": true, "apps/forwarder-sriov": true},
+	} {
+		f, err := images.NewFilter("", profiles[name].exclude, "")
+		require.NoError(t, err)
+		for path, match := range expected {
+			require.Equal(t, match, f.MatchPath("/repo/"+path+"/kustomization.yaml"), "%v: %v", name, path)
+		}
+	}
+}
+
+func TestSuite_ProfileSourcesURLs(t *testing.T) {
+	p, err := getProfile(DefaultProfile)
+	require.NoError(t, err)
+
+	var dir = t.TempDir()
+	var s = &Suite{Repository: "org/repo", Version: "v1.0.0", Dir: dir}
+	sourcesURLs, selected := s.profileSourcesURLs(p, false)
+	require.False(t, selected)
+	require.Equal(t, []string{
+		"file://" + filepath.Join(dir, "external-images.yaml"),
+		"file://" + filepath.Join(dir, "apps"),
+	}, sourcesURLs)
+
+	s.Dir = filepath.Join(dir, "missing")
+	sourcesURLs, _ = s.profileSourcesURLs(p, true)
+	require.Equal(t, p.sourcesURLs("org/repo", "v1.0.0"), sourcesURLs)
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const dockerHubEndpoint = "https://registry-1.docker.io"
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type descriptor struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// manifest is the image manifest or, if Manifests are set, the manifest list.
+type manifest struct {
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []struct {
+		descriptor
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// registryClient reads image manifests with the anonymous access of the registry v2 API.
+type registryClient struct {
+	client *http.Client
+	// platform is os/arch used to choose the manifest from the manifest list.
+	platform string
+	// cacheDir is the directory of the sizes cached by the manifest digest, sizes are not cached if empty.
+	cacheDir string
+}
+
+// size returns the compressed size of the image: the size of the config and the layers of the platform manifest.
+// The digest of the image is resolved with a HEAD request, that is not counted by the Docker Hub pull rate limit,
+// and the manifests are read only if the size of the digest is not cached.
+func (c *registryClient) size(ctx context.Context, image string) (int64, error) {
+	ref, err := images.ParseReference(image)
+	if err != nil {
+		return 0, err
+	}
+	var reference = ref.Digest
+	if reference == "" && c.cacheDir != "" {
+		reference = c.digest(ctx, ref, ref.Tag)
+	}
+	if size, ok := c.cachedSize(reference); ok {
+		return size, nil
+	}
+	if reference == "" {
+		reference = ref.Tag
+	}
+
+	m, err := c.manifest(ctx, ref, reference)
+	if err != nil {
+		return 0, err
+	}
+	if len(m.Manifests) > 0 {
+		var digest string
+		for i := range m.Manifests {
+			if m.Manifests[i].Platform.OS+"/"+m.Manifests[i].Platform.Architecture == c.platform {
+				digest = m.Manifests[i].Digest
+				break
+			}
+		}
+		if digest == "" {
+			return 0, errors.Errorf("image %v has no manifest for %v", image, c.platform)
+		}
+		if m, err = c.manifest(ctx, ref, digest); err != nil {
+			return 0, err
+		}
+	}
+
+	var result = m.Config.Size
+	for _, layer := range m.Layers {
+		result += layer.Size
+	}
+	c.cacheSize(reference, result)
+	return result, nil
+}
+
+// sizeFile returns the cache file of the size of the digest for the platform, empty if the size is not cached.
+func (c *registryClient) sizeFile(digest string) string {
+	if c.cacheDir == "" || !strings.HasPrefix(digest, "sha256:") {
+		return ""
+	}
+	var name = strings.TrimPrefix(digest, "sha256:") + "-" + strings.ReplaceAll(c.platform, "/", "-")
+	return filepath.Join(c.cacheDir, "sizes", name)
+}
+
+func (c *registryClient) cachedSize(digest string) (int64, bool) {
+	var file = c.sizeFile(digest)
+	if file == "" {
+		return 0, false
+	}
+	b, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return size, err == nil
+}
+
+func (c *registryClient) cacheSize(digest string, size int64) {
+	var file = c.sizeFile(digest)
+	if file == "" {
+		return
+	}
+	var err = os.MkdirAll(filepath.Dir(file), 0750)
+	if err == nil {
+		err = ioutil.WriteFile(file, []byte(strconv.FormatInt(size, 10)), 0600)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to cache the size of %v: %v", digest, err.Error())
+	}
+}
+
+// digest returns the digest of the manifest resolved with the HEAD request, empty if it cannot be resolved.
+func (c *registryClient) digest(ctx context.Context, ref *images.Reference, reference string) string {
+	resp, err := c.fetch(ctx, http.MethodHead, ref, reference)
+	if err != nil {
+		return ""
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return resp.Header.Get("Docker-Content-Digest")
+}
+
+func (c *registryClient) manifest(ctx context.Context, ref *images.Reference, reference string) (*manifest, error) {
+	resp, err := c.fetch(ctx, http.MethodGet, ref, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to get %v: %v", resp.Request.URL, resp.Status)
+	}
+	var result = new(manifest)
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %v", resp.Request.URL)
+	}
+	return result, nil
+}
+
+// fetch requests the manifest, the anonymous token is requested if the registry asks for it.
+func (c *registryClient) fetch(ctx context.Context, method string, ref *images.Reference, reference string) (*http.Response, error) {
+	var manifestURL = registryEndpoint(ref.Registry) + "/v2/" + ref.Repository + "/manifests/" + reference
+
+	resp, err := c.do(ctx, method, manifestURL, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		_ = resp.Body.Close()
+		var token string
+		if token, err = c.token(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, errors.Wrapf(err, "failed to authorize %v", manifestURL)
+		}
+		if resp, err = c.do(ctx, method, manifestURL, token); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// token gets the anonymous token for the Bearer challenge.
+func (c *registryClient) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", errors.Errorf("unsupported challenge %q", challenge)
+	}
+	var params = map[string]string{}
+	for _, m := range challengeParamPattern.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.Errorf("invalid realm in challenge %q", challenge)
+	}
+	var query = tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	resp, err := c.do(ctx, http.MethodGet, tokenURL.String(), "")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to get token: %v", resp.Status)
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.Wrap(err, "failed to decode token")
+	}
+	if result.Token == "" {
+		result.Token = result.AccessToken
+	}
+	return result.Token, nil
+}
+
+func (c *registryClient) do(ctx context.Context, method, rawurl, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawurl, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create request for %v", rawurl)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %v", rawurl)
+	}
+	return resp, nil
+}
+
+// registryEndpoint returns the base URL of the registry API, local registries are served by http.
+func registryEndpoint(registry string) string {
+	switch {
+	case registry == "docker.io":
+		return dockerHubEndpoint
+	case registry == "localhost" || strings.HasPrefix(registry, "localhost:") || strings.HasPrefix(registry, "127.0.0.1"):
+		return "http://" + registry
+	default:
+		return "https://" + registry
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
+)
+
+const (
+	namespacePrefix = "prefetch-"
+	prefetchLabel   = "networkservicemesh.io/prefetch"
+	runIDLabel      = "networkservicemesh.io/prefetch-run-id"
+	createdLabel    = "networkservicemesh.io/prefetch-created"
+	maxNameLength   = 63
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// run is a single prefetch run, its resources are created in its own namespace labeled with the run ID and
+// the creation time, so namespaces of crashed runs can be found and deleted by the next runs. Test binaries of
+// the same CI job share the run ID, so the namespace name always has the random suffix unique for the process.
+type run struct {
+	id      string
+	suffix  string
+	created time.Time
+}
+
+// newRun returns the run with the ID converted to a valid label value or with the random ID if it is empty.
+func newRun(id string) *run {
+	id = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(id), "-"), "-")
+	if len(id) > maxNameLength {
+		id = strings.Trim(id[:maxNameLength], "-")
+	}
+	var b = make([]byte, 4)
+	_, _ = rand.Read(b)
+	var suffix = hex.EncodeToString(b)
+	if id == "" {
+		id = suffix
+	}
+	return &run{id: id, suffix: suffix, created: time.Now()}
+}
+
+// namespace returns the namespace name: the ID truncated to fit into the name and the random suffix.
+func (r *run) namespace() string {
+	if r.id == r.suffix {
+		return namespacePrefix + r.suffix
+	}
+	var id = r.id
+	if limit := maxNameLength - len(namespacePrefix) - len(r.suffix) - 1; len(id) > limit {
+		id = strings.Trim(id[:limit], "-")
+	}
+	return namespacePrefix + id + "-" + r.suffix
+}
+
+func (r *run) labels() map[string]string {
+	return map[string]string{
+		prefetchLabel: "true",
+		runIDLabel:    r.id,
+		createdLabel:  strconv.FormatInt(r.created.Unix(), 10),
+	}
+}
+
+// createdAt returns the creation time of the prefetch namespace from the label, or the creation timestamp
+// if the label is missing.
+func createdAt(ns *corev1.Namespace) time.Time {
+	if seconds, err := strconv.ParseInt(ns.Labels[createdLabel], 10, 64); err == nil {
+		return time.Unix(seconds, 0)
+	}
+	return ns.CreationTimestamp.Time
+}
+
+// collectGarbage deletes prefetch namespaces of other runs created more than ttl ago.
+func (p *prefetcher) collectGarbage(ctx context.Context, ttl time.Duration) error {
+	list, err := p.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: prefetchLabel + "=true"})
+	if err != nil {
+		return errors.Wrap(err, "failed to list prefetch namespaces")
+	}
+
+	var errs images.Errors
+	for i := range list.Items {
+		var ns = &list.Items[i]
+		if ns.Name == p.namespace || ns.DeletionTimestamp != nil {
+			continue
+		}
+		var age = time.Since(createdAt(ns))
+		if age < ttl {
+			continue
+		}
+		logrus.Infof("Deleting namespace %v of the stale prefetch run %v created %v ago", ns.Name, ns.Labels[runIDLabel], age.Round(time.Second))
+		err = p.client.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete namespace %v", ns.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// cleanupOnSignal calls cleanup if the process receives SIGINT or SIGTERM and then raises the signal again,
+// so the process terminates as usual. The returned function stops waiting for the signals.
+func cleanupOnSignal(cleanup func()) (stop func()) {
+	var signals = make(chan os.Signal, 1)
+	var done = make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			logrus.Warnf("Received %v, cleaning up the prefetch", sig)
+			cleanup()
+			signal.Stop(signals)
+			if process, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = process.Signal(sig)
+			}
+		case <-done:
+			signal.Stop(signals)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
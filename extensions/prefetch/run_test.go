@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewRun(t *testing.T) {
+	var ci = newRun("CI_1234/2")
+	require.Regexp(t, "^prefetch-ci-1234-2-[0-9a-f]{8}$", ci.namespace())
+	require.Equal(t, "ci-1234-2", ci.labels()[runIDLabel])
+	// Test binaries of the same CI job use their own namespaces
+	require.NotEqual(t, ci.namespace(), newRun("CI_1234/2").namespace())
+
+	var long = newRun(strings.Repeat("a", 100))
+	require.Len(t, long.namespace(), maxNameLength)
+	require.Len(t, long.labels()[runIDLabel], maxNameLength)
+
+	var r = newRun("")
+	require.Regexp(t, "^prefetch-[0-9a-f]{8}$", r.namespace())
+	require.Equal(t, r.id, r.labels()[runIDLabel])
+	require.NotEqual(t, r.id, newRun("").id)
+}
+
+func TestPrefetcher_CollectGarbage(t *testing.T) {
+	var ns = func(name string, age time.Duration, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		}}
+	}
+	var stale = &run{id: "stale", created: time.Now().Add(-2 * time.Hour)}
+	var fresh = &run{id: "fresh", created: time.Now().Add(-time.Minute)}
+	var current = &run{id: "current", created: time.Now().Add(-3 * time.Hour)}
+
+	var client = fake.NewSimpleClientset(
+		ns(stale.namespace(), 0, stale.labels()),
+		ns(fresh.namespace(), 0, fresh.labels()),
+		ns(current.namespace(), 0, current.labels()),
+		ns("prefetch-unlabeled", 3*time.Hour, nil),
+		ns("prefetch-legacy", 3*time.Hour, map[string]string{prefetchLabel: "true"}),
+		ns("prefetch-invalid", time.Minute, map[string]string{prefetchLabel: "true", createdLabel: "invalid"}),
+	)
+	var p = &prefetcher{client: client, namespace: current.namespace()}
+	require.NoError(t, p.collectGarbage(context.Background(), time.Hour))
+
+	list, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for i := range list.Items {
+		names = append(names, list.Items[i].Name)
+	}
+	require.ElementsMatch(t, []string{fresh.namespace(), current.namespace(), "prefetch-unlabeled", "prefetch-invalid"}, names)
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	readmeFileName  = "README.md"
+	examplesDirName = "examples"
+	requiresSection = "Requires"
+	includesSection = "Includes"
+)
+
+var (
+	applyPattern = regexp.MustCompile(`kubectl\s+apply\s+-k\s+(\S+)`)
+	linkPattern  = regexp.MustCompile(`\[[^\]]*\]\(([^)]+)\)`)
+	namePattern  = regexp.MustCompile("[^a-zA-Z0-9]+")
+)
+
+// example is a README.md of the examples directory of the deployments repository, gotestmd generates a suite
+// for the example with includes and a test for the example without them.
+type example struct {
+	dir      string
+	applies  []string
+	requires []string
+	includes []string
+	parents  []string
+}
+
+// title returns the name used by gotestmd for the suite or the test of the example.
+func (e *example) title() string {
+	var name = namePattern.ReplaceAllString(filepath.Base(e.dir), "_")
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (e *example) isLeaf() bool {
+	return len(e.includes) == 0
+}
+
+// parseExamples parses all examples of the repository, the result is indexed by the example directory.
+func parseExamples(repoDir string) (map[string]*example, error) {
+	var result = map[string]*example{}
+	err := filepath.Walk(filepath.Join(repoDir, examplesDirName), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != readmeFileName {
+			return err
+		}
+		content, err := ioutil.ReadFile(filepath.Clean(p))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %v", p)
+		}
+		var e = parseExample(repoDir, filepath.Dir(p), content)
+		result[e.dir] = e
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse examples")
+	}
+
+	for dir, e := range result {
+		for _, include := range e.includes {
+			if child, ok := result[include]; ok {
+				child.parents = append(child.parents, dir)
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseExample finds `kubectl apply -k` directories and links of the Requires and Includes sections of README.md.
+func parseExample(repoDir, dir string, content []byte) *example {
+	var e = &example{dir: dir}
+	var section string
+	var code bool
+
+	var scanner = bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "```"):
+			code = !code
+		case code:
+			for _, m := range applyPattern.FindAllStringSubmatch(line, -1) {
+				if applyDir, ok := resolveApplyDir(repoDir, dir, m[1]); ok {
+					e.applies = append(e.applies, applyDir)
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+			section = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		case section == requiresSection || section == includesSection:
+			for _, m := range linkPattern.FindAllStringSubmatch(line, -1) {
+				var link = filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(m[1], readmeFileName)))
+				if section == requiresSection {
+					e.requires = append(e.requires, link)
+				} else {
+					e.includes = append(e.includes, link)
+				}
+			}
+		}
+	}
+	return e
+}
+
+// resolveApplyDir returns the local directory of the `kubectl apply -k` argument. Github URLs of the repository
+// are resolved to the repository directory, arguments with variables are skipped.
+func resolveApplyDir(repoDir, dir, arg string) (string, bool) {
+	if strings.Contains(arg, "$") {
+		return "", false
+	}
+	if !strings.Contains(arg, "://") {
+		return filepath.Join(dir, filepath.FromSlash(arg)), true
+	}
+
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", false
+	}
+	// github.com/org/repo/path
+	var segments = strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 3 || segments[1] != filepath.Base(repoDir) {
+		return "", false
+	}
+	segments = segments[2:]
+	if (segments[0] == "tree" || segments[0] == "blob") && len(segments) > 2 {
+		segments = segments[2:]
+	}
+	return filepath.Join(append([]string{repoDir}, segments...)...), true
+}
+
+// testSelector matches names of gotestmd tests and suites selected by the -test.run and -testify.m flags.
+// Each level of the -test.run pattern is matched separately, so both TestMemif and TestRunFeatureSuite/TestMemif select
+// the memif test.
+type testSelector []*regexp.Regexp
+
+func newTestSelector() testSelector {
+	var result testSelector
+	for _, name := range []string{"test.run", "testify.m"} {
+		var f = flag.Lookup(name)
+		if f == nil || f.Value.String() == "" {
+			continue
+		}
+		for _, level := range strings.Split(f.Value.String(), "/") {
+			if re, err := regexp.Compile(level); err == nil && level != "" {
+				result = append(result, re)
+			}
+		}
+	}
+	return result
+}
+
+func (s testSelector) match(name string) bool {
+	for _, re := range s {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDirs returns directories applied by the selected examples, their parent suites and required examples.
+// Returns nil if no example is selected.
+func selectDirs(examples map[string]*example, selector testSelector) []string {
+	var selected []*example
+	for _, e := range examples {
+		if e.isLeaf() && selector.match("Test"+e.title()) || !e.isLeaf() && selector.match(e.title()) {
+			selected = append(selected, e)
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+
+	var w = newDirsWalker(examples)
+	for _, e := range selected {
+		w.visit(e, true)
+	}
+	return w.sortedDirs()
+}
+
+// dirsWalker collects directories applied by the examples.
+type dirsWalker struct {
+	examples          map[string]*example
+	visited, expanded map[string]bool
+	dirs              map[string]bool
+}
+
+func newDirsWalker(examples map[string]*example) *dirsWalker {
+	return &dirsWalker{examples: examples, visited: map[string]bool{}, expanded: map[string]bool{}, dirs: map[string]bool{}}
+}
+
+func (w *dirsWalker) sortedDirs() []string {
+	var result []string
+	for dir := range w.dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// visit collects directories of the example, its required examples and parent suites and, if down is true,
+// of the included examples.
+func (w *dirsWalker) visit(e *example, down bool) {
+	if down {
+		if w.expanded[e.dir] {
+			return
+		}
+		w.expanded[e.dir] = true
+	} else if w.visited[e.dir] {
+		return
+	}
+	w.visited[e.dir] = true
+	for _, dir := range e.applies {
+		w.dirs[dir] = true
+	}
+	for _, link := range e.requires {
+		if required, ok := w.examples[link]; ok {
+			w.visit(required, false)
+		}
+	}
+	for _, link := range e.parents {
+		w.visit(w.examples[link], false)
+	}
+	if down {
+		for _, link := range e.includes {
+			if child, ok := w.examples[link]; ok {
+				w.visit(child, true)
+			}
+		}
+	}
+}
+
+// selectionEntry is the cached list of images of the tests selected in the suite. The images are valid for
+// the sources filtered by the path rules and for the digest of the local repository manifests.
+type selectionEntry struct {
+	Version   string   `json:"version"`
+	Sources   []string `json:"sources"`
+	PathRules []string `json:"pathRules"`
+	Digest    string   `json:"digest"`
+	Images    []string `json:"images"`
+}
+
+func (e *selectionEntry) equal(other *selectionEntry) bool {
+	if e == nil || other == nil || e.Version != other.Version || e.Digest != other.Digest {
+		return false
+	}
+	return equalStrings(e.Sources, other.Sources) && equalStrings(e.PathRules, other.PathRules)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestsDigest returns the hash of paths, sizes and modification times of the yaml files in the dir, so any
+// change of the local repository manifests invalidates the cached selection.
+func manifestsDigest(dir string) (string, error) {
+	var hash = sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && (strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+			_, _ = fmt.Fprintf(hash, "%v %v %v\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to walk %v", dir)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func selectionFile(cacheDir, suiteName string) string {
+	return filepath.Join(cacheDir, "suites", namePattern.ReplaceAllString(suiteName, "_")+".json")
+}
+
+// loadSelection returns the cached selection of the suite or nil if there is no valid one.
+func loadSelection(cacheDir, suiteName string) *selectionEntry {
+	b, err := ioutil.ReadFile(selectionFile(cacheDir, suiteName))
+	if err != nil {
+		return nil
+	}
+	var result = new(selectionEntry)
+	if err := json.Unmarshal(b, result); err != nil {
+		return nil
+	}
+	return result
+}
+
+func storeSelection(cacheDir, suiteName string, entry *selectionEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal selection")
+	}
+	var file = selectionFile(cacheDir, suiteName)
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return errors.Wrap(err, "failed to create cache dir")
+	}
+	return errors.Wrap(ioutil.WriteFile(file, b, 0600), "failed to write selection")
+}
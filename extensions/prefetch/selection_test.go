@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testExamples = map[string]string{
+	"basic": "# Basic\n\n## Includes\n\n- [Memif](../memif)\n- [Kernel](../kernel/README.md)\n\n## Run\n\n" +
+		"```bash\nkubectl apply -k .\n```\n\n## Cleanup\n\n```bash\nkubectl delete -k .\n```\n",
+	"memif": "# Memif\n\n## Requires\n\n- [Spire](../spire)\n\n## Run\n\n" +
+		"```bash\nkubectl apply -k https://github.com/networkservicemesh/deployments-k8s/examples/memif/nse?ref=v1.0.0\n```\n",
+	"kernel": "# Kernel\n\n## Run\n\n```bash\nkubectl apply -k ./nse\nkubectl apply -k ${WH}\n```\n",
+	"spire":  "# Spire\n\n## Run\n\n```bash\nkubectl apply -k ./spire\n```\n",
+}
+
+func writeExamples(t *testing.T) string {
+	var repoDir = filepath.Join(t.TempDir(), "deployments-k8s")
+	for name, readme := range testExamples {
+		var dir = filepath.Join(repoDir, examplesDirName, name)
+		require.NoError(t, os.MkdirAll(dir, 0750))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, readmeFileName), []byte(readme), 0600))
+	}
+	return repoDir
+}
+
+func TestSelectDirs(t *testing.T) {
+	var repoDir = writeExamples(t)
+	var examplesDir = filepath.Join(repoDir, examplesDirName)
+
+	examples, err := parseExamples(repoDir)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		filepath.Join(examplesDir, "basic"),
+		filepath.Join(examplesDir, "memif", "nse"),
+		filepath.Join(examplesDir, "spire", "spire"),
+	}, selectDirs(examples, testSelector{regexp.MustCompile("TestMemif")}))
+
+	require.Equal(t, []string{
+		filepath.Join(examplesDir, "basic"),
+		filepath.Join(examplesDir, "kernel", "nse"),
+		filepath.Join(examplesDir, "memif", "nse"),
+		filepath.Join(examplesDir, "spire", "spire"),
+	}, selectDirs(examples, testSelector{regexp.MustCompile("TestRunBasicSuite"), regexp.MustCompile("^Basic$")}))
+
+	require.Nil(t, selectDirs(examples, testSelector{regexp.MustCompile("TestRunBasicSuite")}))
+}
+
+func TestSelectionCache(t *testing.T) {
+	var dir = t.TempDir()
+	var entry = &selectionEntry{
+		Version:   "v1.0.0",
+		Sources:   []string{"file:///apps"},
+		PathRules: []string{"exclude path:-sriov"},
+		Digest:    "digest",
+		Images:    []string{"alpine"},
+	}
+
+	require.Nil(t, loadSelection(dir, "TestRunBasicSuite"))
+	require.NoError(t, storeSelection(dir, "TestRunBasicSuite", entry))
+
+	var cached = loadSelection(dir, "TestRunBasicSuite")
+	require.True(t, cached.equal(entry))
+	require.Equal(t, entry.Images, cached.Images)
+	require.False(t, cached.equal(&selectionEntry{Version: "v1.0.1", Sources: entry.Sources, PathRules: entry.PathRules, Digest: entry.Digest}))
+	require.False(t, cached.equal(&selectionEntry{Version: entry.Version, Sources: entry.Sources, Digest: entry.Digest}))
+	require.False(t, cached.equal(&selectionEntry{Version: entry.Version, Sources: entry.Sources, PathRules: entry.PathRules, Digest: "changed"}))
+}
+
+func TestManifestsDigest(t *testing.T) {
+	var dir = t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nse.yaml"), []byte("image: alpine\n"), 0600))
+
+	digest, err := manifestsDigest(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# NSE\n"), 0600))
+	unchanged, err := manifestsDigest(dir)
+	require.NoError(t, err)
+	require.Equal(t, digest, unchanged)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nse.yaml"), []byte("image: nginx:1.21\n"), 0600))
+	changed, err := manifestsDigest(dir)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, changed)
+}
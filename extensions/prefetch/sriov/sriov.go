@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -14,15 +14,6 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package sriov can be used to enable prefetching of SRIOV related applications
+// Package sriov is kept for compatibility and does nothing. SRIOV related applications are prefetched by suites
+// with the sriov prefetch Profile or with PREFETCH_PROFILE=sriov.
 package sriov
-
-import (
-	"github.com/networkservicemesh/integration-tests/extensions/prefetch"
-)
-
-func init() {
-	prefetch.IsExcluded = func(_ string) bool {
-		return false
-	}
-}
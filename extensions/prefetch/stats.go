@@ -0,0 +1,274 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	statsJSONFileName     = "prefetch-stats.json"
+	statsMarkdownFileName = "prefetch-stats.md"
+	pulledReason          = "Pulled"
+)
+
+var (
+	pulledPattern  = regexp.MustCompile(`^Successfully pulled image "([^"]+)" in ([0-9][0-9.a-zµ]*)`)
+	presentPattern = regexp.MustCompile(`^Container image "([^"]+)" already present on machine`)
+)
+
+// pullStat is the pull statistics of the image on the node.
+type pullStat struct {
+	Image    string `json:"image"`
+	Node     string `json:"node"`
+	Workload string `json:"workload"`
+	// PullSeconds is the pull duration from the kubelet Pulled event.
+	PullSeconds *float64 `json:"pullSeconds,omitempty"`
+	// StartSeconds is the time between the start of the pod or the end of the previous container and the start of
+	// the container from the container statuses, it includes the pull.
+	StartSeconds *float64 `json:"startSeconds,omitempty"`
+	// Present is true if the kubelet reported that the image is already present on the node.
+	Present bool `json:"present"`
+}
+
+// pullStats collects pull statistics of the prefetch workloads.
+type pullStats struct {
+	mu      sync.Mutex
+	started time.Time
+	pulls   []*pullStat
+}
+
+func newPullStats() *pullStats {
+	return &pullStats{started: time.Now()}
+}
+
+// recordStats adds statistics of the pods of the workload. Does nothing if the statistics are disabled.
+func (p *prefetcher) recordStats(workload string, pods map[string]*corev1.Pod) {
+	if p.stats == nil || len(pods) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+
+	var pulls = podPullStats(workload, pods, p.podEvents(ctx))
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	p.stats.pulls = append(p.stats.pulls, pulls...)
+}
+
+// podPullStats returns statistics of the started containers of the pods from the container statuses
+// and the kubelet Pulled events.
+func podPullStats(workload string, pods map[string]*corev1.Pod, events map[string][]corev1.Event) []*pullStat {
+	var result []*pullStat
+	for _, pod := range pods {
+		var pulled, present = pulledEvents(events[pod.Name])
+		var statuses = map[string]*corev1.ContainerStatus{}
+		for _, list := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+			for i := range list {
+				statuses[list[i].Name] = &list[i]
+			}
+		}
+
+		var previous time.Time
+		if pod.Status.StartTime != nil {
+			previous = pod.Status.StartTime.Time
+		}
+		var containers = append(append([]corev1.Container(nil), pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for i := range containers {
+			started, finished := containerTimes(statuses[containers[i].Name])
+			if started.IsZero() {
+				continue
+			}
+			var name, image = containers[i].Name, containers[i].Image
+			if name != returnContainerName && name != pauseContainerName {
+				var s = &pullStat{Image: image, Node: pod.Spec.NodeName, Workload: workload, Present: present[image]}
+				if d, ok := pulled[image]; ok {
+					s.PullSeconds = seconds(d)
+				}
+				if !previous.IsZero() && started.After(previous) {
+					s.StartSeconds = seconds(started.Sub(previous))
+				}
+				result = append(result, s)
+			}
+			if previous = started; !finished.IsZero() {
+				previous = finished
+			}
+		}
+	}
+	return result
+}
+
+// containerTimes returns the start and the finish time of the container, zero if unknown.
+func containerTimes(status *corev1.ContainerStatus) (started, finished time.Time) {
+	switch {
+	case status == nil:
+	case status.State.Running != nil:
+		started = status.State.Running.StartedAt.Time
+	case status.State.Terminated != nil:
+		started, finished = status.State.Terminated.StartedAt.Time, status.State.Terminated.FinishedAt.Time
+	}
+	return started, finished
+}
+
+// pulledEvents returns pull durations and already present images from the Pulled events.
+func pulledEvents(events []corev1.Event) (pulled map[string]time.Duration, present map[string]bool) {
+	pulled, present = map[string]time.Duration{}, map[string]bool{}
+	for i := range events {
+		if events[i].Reason != pulledReason {
+			continue
+		}
+		if m := pulledPattern.FindStringSubmatch(events[i].Message); m != nil {
+			if d, err := time.ParseDuration(m[2]); err == nil {
+				pulled[m[1]] = d
+			}
+		} else if m := presentPattern.FindStringSubmatch(events[i].Message); m != nil {
+			present[m[1]] = true
+		}
+	}
+	return pulled, present
+}
+
+// podEvents returns events of the namespace by the name of the involved object.
+func (p *prefetcher) podEvents(ctx context.Context) map[string][]corev1.Event {
+	var result = map[string][]corev1.Event{}
+	if list, err := p.client.CoreV1().Events(p.namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		for i := range list.Items {
+			var name = list.Items[i].InvolvedObject.Name
+			result[name] = append(result[name], list.Items[i])
+		}
+	}
+	return result
+}
+
+func seconds(d time.Duration) *float64 {
+	var result = d.Seconds()
+	return &result
+}
+
+// statsReport is the summary of the prefetch statistics.
+type statsReport struct {
+	Repository      string          `json:"repository"`
+	Version         string          `json:"version"`
+	Started         time.Time       `json:"started"`
+	DurationSeconds float64         `json:"durationSeconds"`
+	Images          []*imageSummary `json:"images"`
+	Pulls           []*pullStat     `json:"pulls"`
+}
+
+// imageSummary is the pull statistics of the image on all nodes.
+type imageSummary struct {
+	Image           string  `json:"image"`
+	Nodes           int     `json:"nodes"`
+	Present         int     `json:"present"`
+	MaxPullSeconds  float64 `json:"maxPullSeconds"`
+	MeanPullSeconds float64 `json:"meanPullSeconds"`
+}
+
+// report returns the summary of the statistics, images are sorted from the slowest.
+func (s *pullStats) report(repository, version string) *statsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result = &statsReport{
+		Repository:      repository,
+		Version:         version,
+		Started:         s.started,
+		DurationSeconds: time.Since(s.started).Seconds(),
+		Pulls:           append([]*pullStat(nil), s.pulls...),
+	}
+	sort.Slice(result.Pulls, func(i, j int) bool {
+		if result.Pulls[i].Image != result.Pulls[j].Image {
+			return result.Pulls[i].Image < result.Pulls[j].Image
+		}
+		return result.Pulls[i].Node < result.Pulls[j].Node
+	})
+
+	var summaries = map[string]*imageSummary{}
+	var pulled = map[string]int{}
+	for _, pull := range result.Pulls {
+		var summary, ok = summaries[pull.Image]
+		if !ok {
+			summary = &imageSummary{Image: pull.Image}
+			summaries[pull.Image] = summary
+			result.Images = append(result.Images, summary)
+		}
+		summary.Nodes++
+		if pull.Present {
+			summary.Present++
+		}
+		var d = pull.PullSeconds
+		if d == nil {
+			d = pull.StartSeconds
+		}
+		if d != nil && !pull.Present {
+			pulled[pull.Image]++
+			summary.MeanPullSeconds += (*d - summary.MeanPullSeconds) / float64(pulled[pull.Image])
+			if *d > summary.MaxPullSeconds {
+				summary.MaxPullSeconds = *d
+			}
+		}
+	}
+	sort.SliceStable(result.Images, func(i, j int) bool {
+		return result.Images[i].MaxPullSeconds > result.Images[j].MaxPullSeconds
+	})
+	return result
+}
+
+// markdown returns the summary table of the report.
+func (r *statsReport) markdown() string {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "# Prefetch statistics\n\n")
+	_, _ = fmt.Fprintf(&sb, "Repository: %v, version: %v\n\n", r.Repository, r.Version)
+	_, _ = fmt.Fprintf(&sb, "Started at %v, took %.1fs\n\n", r.Started.UTC().Format(time.RFC3339), r.DurationSeconds)
+	_, _ = fmt.Fprintf(&sb, "| Image | Nodes | Already present | Max pull, s | Mean pull, s |\n")
+	_, _ = fmt.Fprintf(&sb, "| --- | ---: | ---: | ---: | ---: |\n")
+	for _, image := range r.Images {
+		_, _ = fmt.Fprintf(&sb, "| %v | %v | %v | %.1f | %.1f |\n",
+			image.Image, image.Nodes, image.Present, image.MaxPullSeconds, image.MeanPullSeconds)
+	}
+	return sb.String()
+}
+
+// writeStats writes the report of the statistics as JSON and Markdown to the directory.
+func writeStats(dir string, r *statsReport) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to write prefetch statistics")
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to write prefetch statistics")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, statsJSONFileName), b, os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to write prefetch statistics")
+	}
+	return errors.Wrap(ioutil.WriteFile(filepath.Join(dir, statsMarkdownFileName), []byte(r.markdown()), os.ModePerm),
+		"failed to write prefetch statistics")
+}
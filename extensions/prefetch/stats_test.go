@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func terminated(start time.Time, started, finished time.Duration) corev1.ContainerState {
+	return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+		StartedAt:  metav1.NewTime(start.Add(started)),
+		FinishedAt: metav1.NewTime(start.Add(finished)),
+	}}
+}
+
+func pulledEvent(pod, message string) corev1.Event {
+	return corev1.Event{InvolvedObject: corev1.ObjectReference{Name: pod}, Reason: pulledReason, Message: message}
+}
+
+func TestPodPullStats(t *testing.T) {
+	var start = time.Now().Add(-time.Minute)
+	var pod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prefetch-0-a"},
+		Spec:       daemonSet(testNamespace, 0, []string{"alpine", "nginx", "busybox"}, &podOptions{returnImage: "return", pauseImage: "pause"}).Spec.Template.Spec,
+		Status: corev1.PodStatus{
+			StartTime: &metav1.Time{Time: start},
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: returnContainerName, State: terminated(start, time.Second, 2*time.Second)},
+				{Name: "image-0", State: terminated(start, 5*time.Second, 6*time.Second)},
+				{Name: "image-1", State: terminated(start, 6500*time.Millisecond, 7*time.Second)},
+				{Name: "image-2", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}}},
+			},
+		},
+	}
+	pod.Spec.NodeName = "a"
+
+	var stats = podPullStats("daemonset prefetch-0", map[string]*corev1.Pod{pod.Name: pod}, map[string][]corev1.Event{
+		pod.Name: {
+			pulledEvent(pod.Name, `Successfully pulled image "alpine" in 2.5s (2.5s including waiting)`),
+			pulledEvent(pod.Name, `Container image "nginx" already present on machine`),
+			{Reason: "Failed", Message: `Failed to pull image "busybox"`},
+		},
+	})
+	require.Len(t, stats, 2)
+	require.Equal(t, &pullStat{Image: "alpine", Node: "a", Workload: "daemonset prefetch-0", PullSeconds: seconds(2500 * time.Millisecond), StartSeconds: seconds(3 * time.Second)}, stats[0])
+	require.Equal(t, &pullStat{Image: "nginx", Node: "a", Workload: "daemonset prefetch-0", StartSeconds: seconds(500 * time.Millisecond), Present: true}, stats[1])
+}
+
+func TestPullStats_Report(t *testing.T) {
+	var stats = newPullStats()
+	stats.pulls = []*pullStat{
+		{Image: "nginx", Node: "b", PullSeconds: seconds(4 * time.Second)},
+		{Image: "alpine", Node: "a", PullSeconds: seconds(time.Second)},
+		{Image: "nginx", Node: "a", PullSeconds: seconds(2 * time.Second)},
+		{Image: "alpine", Node: "b", Present: true},
+		{Image: "busybox", Node: "a", StartSeconds: seconds(3 * time.Second)},
+	}
+
+	var report = stats.report("org/repo", "v1.0.0")
+	require.Equal(t, []*imageSummary{
+		{Image: "nginx", Nodes: 2, MaxPullSeconds: 4, MeanPullSeconds: 3},
+		{Image: "busybox", Nodes: 1, MaxPullSeconds: 3, MeanPullSeconds: 3},
+		{Image: "alpine", Nodes: 2, Present: 1, MaxPullSeconds: 1, MeanPullSeconds: 1},
+	}, report.Images)
+	require.Equal(t, "alpine", report.Pulls[0].Image)
+	require.Contains(t, report.markdown(), "| nginx | 2 | 0 | 4.0 | 3.0 |\n")
+
+	var dir = t.TempDir()
+	require.NoError(t, writeStats(dir, report))
+	b, err := ioutil.ReadFile(filepath.Join(dir, statsJSONFileName))
+	require.NoError(t, err)
+	var decoded statsReport
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, "v1.0.0", decoded.Version)
+	require.Len(t, decoded.Pulls, 5)
+	require.FileExists(t, filepath.Join(dir, statsMarkdownFileName))
+}
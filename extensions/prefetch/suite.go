@@ -1,4 +1,4 @@
-// Copyright (c) 2021 Doc.ai and/or its affiliates.
+// Copyright (c) 2021-2022 Doc.ai and/or its affiliates.
 //
 // SPDX-License-Identifier: Apache-2.0
 //
@@ -18,14 +18,20 @@
 package prefetch
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/networkservicemesh/gotestmd/pkg/suites/shell"
 	"github.com/networkservicemesh/integration-tests/extensions/prefetch/images"
@@ -33,17 +39,66 @@ import (
 
 // Config is env config to setup images prefetching.
 type Config struct {
-	ImagesPerDaemonset int    `default:"10" desc:"Number of images created per DaemonSet" split_words:"true"`
-	Timeout            string `default:"10m" desc:"Kubectl rollout status timeout for the DaemonSet" split_words:"true"`
+	Profile              string            `default:"" desc:"Prefetch profile: default, sriov, vfio, interdomain, heal or all, overrides the suite profile" split_words:"true"`
+	ImagesPerDaemonset   int               `default:"10" desc:"Maximum number of images per DaemonSet" split_words:"true"`
+	Timeout              time.Duration     `default:"10m" desc:"Rollout timeout for the DaemonSet" split_words:"true"`
+	PlanBySize           bool              `default:"false" desc:"Pack images into DaemonSets by compressed sizes from the registries" split_words:"true"`
+	DaemonsetBudget      string            `default:"2Gi" desc:"Compressed size of images per DaemonSet if PlanBySize is set" split_words:"true"`
+	Platform             string            `default:"linux/amd64" desc:"Platform of the images used to get sizes from manifest lists" split_words:"true"`
+	SkipPresent          bool              `default:"true" desc:"Skip images that are already present on all schedulable nodes" split_words:"true"`
+	Mode                 string            `default:"daemonset" desc:"Prefetch mode: daemonset uses the return and the pause helper images, job runs a Job per node without helper images" split_words:"true"`
+	ReturnImage          string            `default:"rrandom312/return" desc:"Helper image with the /bin/return binary for the daemonset mode" split_words:"true"`
+	PauseImage           string            `default:"registry.k8s.io/pause:3.6" desc:"Pause image of the DaemonSet pods" split_words:"true"`
+	TolerateAll          bool              `default:"false" desc:"Tolerate all taints, so images are prefetched to control plane and dedicated nodes too" split_words:"true"`
+	NodeSelector         map[string]string `default:"" desc:"Node selector of the prefetch pods in the key:value,key:value form" split_words:"true"`
+	PullSecrets          []string          `default:"" desc:"Comma separated pull secrets copied to the prefetch namespace, name or namespace/name" split_words:"true"`
+	PullSecretsNamespace string            `default:"default" desc:"Namespace of the pull secrets without namespace" split_words:"true"`
+	RunID                string            `default:"" desc:"ID of the run used in the prefetch namespace name and label, e.g. the CI job ID, random if empty" split_words:"true"`
+	NamespaceTTL         time.Duration     `default:"1h" desc:"Prefetch namespaces of other runs older than the TTL are deleted, 0 disables the deletion" split_words:"true"`
+	Background           bool              `default:"false" desc:"Prefetch images in the background, tests wait only for their images before the start" split_words:"true"`
+	Parallelism          int               `default:"2" desc:"Number of DaemonSets prefetching images in the background at once" split_words:"true"`
+	Priority             []string          `default:"networkservicemesh/" desc:"Comma separated regexes of images prefetched first in the background, in the order of priority" split_words:"true"`
+	DryRun               bool              `default:"false" desc:"Write the prefetch manifests and the plan to the ArtifactsDir instead of applying them, the cluster is not used" split_words:"true"`
+	ArtifactsDir         string            `default:"logs" desc:"Directory for the dry run output and the pull statistics" envconfig:"ARTIFACTS_DIR"`
+	Marker               bool              `default:"true" desc:"Share the prefetch between test binaries with a marker ConfigMap and a Lease, so it is done once" split_words:"true"`
+	MarkerNamespace      string            `default:"default" desc:"Namespace of the prefetch marker ConfigMaps and Leases" split_words:"true"`
+	MarkerTTL            time.Duration     `default:"12h" desc:"Prefetch marker older than the TTL is ignored and the images are prefetched again" split_words:"true"`
+	KubeConfig           string            `default:"" desc:".kube config file path" envconfig:"KUBECONFIG"`
+	Strict               bool              `default:"false" desc:"Fail the suite if any images source cannot be read" split_words:"true"`
+	GithubToken          string            `default:"" desc:"Token for github API requests, anonymous requests are used if empty" envconfig:"GITHUB_TOKEN"`
+	Cache                bool              `default:"true" desc:"Cache remote image sources and image sizes on disk" split_words:"true"`
+	CacheDir             string            `default:"" desc:"Directory for cached image sources, user cache directory is used if empty" split_words:"true"`
+	Include              string            `default:"" desc:"Regex of images to prefetch, path: and image: prefixes select the target" split_words:"true"`
+	Exclude              *string           `desc:"Regex of source paths and images to skip, path: and image: prefixes select the target, the profile exclusion is used if not set" split_words:"true"`
+	FilterFile           string            `default:"" desc:"File with include and exclude rules, one rule per line" split_words:"true"`
+	ImageMirrors         []string          `default:"" desc:"Comma separated registry mirrors in the prefix=mirror form, e.g. ghcr.io=localhost:5000/ghcr" split_words:"true"`
+	ImageRewrites        []string          `default:"" desc:"Comma separated image rewrites in the regex=replacement form, applied after mirrors" split_words:"true"`
+	SelectedTests        bool              `default:"true" desc:"Prefetch only images of the tests selected with -run or -testify.m, requires the local repository" split_words:"true"`
 }
 
-// Suite creates `prefetch` daemonset which pulls all test images for all cluster nodes.
+// Suite creates `prefetch` daemonsets which pull all test images for all cluster nodes. Daemonsets are created in
+// the namespace of the run which is deleted after the prefetch, namespaces of interrupted runs are deleted by the next
+// runs after the NamespaceTTL. Test binaries prefetching the same images to the same nodes share the prefetch through
+// a marker in the MarkerNamespace.
+// Images are searched in SourcesURLs if set, otherwise in the profile sources of the local Dir of the repository
+// or, if the Dir doesn't exist, of the github Repository of the Version.
 type Suite struct {
 	shell.Suite
 	SourcesURLs []string
+	Repository  string
+	Version     string
+	Dir         string
+	// Profile is the name of the prefetch profile, DefaultProfile is used if empty.
+	Profile string
+	// Client is used to create DaemonSets, the client for KUBECONFIG is used if nil.
+	Client kubernetes.Interface
 }
 
-var once sync.Once
+var (
+	once sync.Once
+	// prefetched is the prefetch running in the background, nil if the images are prefetched in SetupSuite.
+	prefetched *background
+)
 
 // SetupSuite prefetches docker images for each k8s node.
 func (s *Suite) SetupSuite() {
@@ -55,59 +110,367 @@ func (s *Suite) initialize() {
 	require.NoError(s.T(), envconfig.Usage("prefetch", &config))
 	require.NoError(s.T(), envconfig.Process("prefetch", &config))
 
-	prefetchImages := images.ReteriveList(s.SourcesURLs, func(s string) bool {
-		return strings.HasSuffix(s, ".yaml") && !IsExcluded(s)
-	}).Images
+	prefetchImages, sources := s.images(&config)
+	if config.DryRun {
+		s.dryRun(&config, prefetchImages, sources)
+		return
+	}
+
+	var client = s.Client
+	if client == nil {
+		var err error
+		client, err = newClient(config.KubeConfig)
+		require.NoError(s.T(), err)
+	}
+
+	var r = newRun(config.RunID)
+	var p = &prefetcher{
+		client:    client,
+		namespace: r.namespace(),
+		labels:    r.labels(),
+		timeout:   config.Timeout,
+		mode:      config.Mode,
+		pod: podOptions{
+			tolerateAll:  config.TolerateAll,
+			nodeSelector: config.NodeSelector,
+			returnImage:  config.ReturnImage,
+			pauseImage:   config.PauseImage,
+		},
+		stats: newPullStats(),
+	}
+	require.Contains(s.T(), []string{daemonSetMode, jobMode}, config.Mode, "unknown prefetch mode")
+
+	var m *marker
+	if config.Marker {
+		var done bool
+		if m, done = s.waitMarker(p, &config, fmt.Sprintf("%v-%v", r.id, os.Getpid()), prefetchImages); done {
+			logrus.Info("Images are already prefetched by another test binary")
+			return
+		}
+	}
+
+	var release = func(completed bool) {
+		if m == nil {
+			return
+		}
+		if err := m.release(context.Background(), completed); err != nil {
+			logrus.Warn(err.Error())
+		}
+	}
+	if config.Background {
+		s.prefetchInBackground(p, &config, r, prefetchImages, release)
+		return
+	}
+
+	var completed bool
+	defer func() { release(completed) }()
+	s.prefetch(p, &config, r, prefetchImages)
+	completed = true
+}
+
+// waitMarker returns true if the images are already prefetched by another test binary. Otherwise waits until the
+// marker is held by this binary and returns it, or returns nil if the marker cannot be used.
+func (s *Suite) waitMarker(p *prefetcher, config *Config, holder string, list []string) (*marker, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout+markerLeaseDuration)
+	defer cancel()
+
+	m, err := p.newMarker(ctx, config.MarkerNamespace, holder, config.MarkerTTL, list)
+	if err != nil {
+		logrus.Warnf("Prefetch is not shared with other test binaries: %v", err.Error())
+		return nil, false
+	}
+	if err = m.collectGarbage(ctx); err != nil {
+		logrus.Warnf("Expired prefetch markers are not deleted: %v", err.Error())
+	}
+	if config.Background {
+		return tryMarker(ctx, m)
+	}
+
+	done, err := m.wait(ctx)
+	if err != nil {
+		logrus.Warnf("Prefetch is not shared with other test binaries: %v", err.Error())
+		return nil, false
+	}
+	return m, done
+}
+
+// tryMarker is waitMarker of the background prefetch, it doesn't wait for the marker held by another test binary
+// and returns nil, so the images are prefetched in the background without the marker.
+func tryMarker(ctx context.Context, m *marker) (*marker, bool) {
+	done, acquired, holder, err := m.try(ctx)
+	switch {
+	case err != nil:
+		logrus.Warnf("Prefetch is not shared with other test binaries: %v", err.Error())
+		return nil, false
+	case done || acquired:
+		return m, done
+	default:
+		logrus.Infof("Prefetch %v is held by %v, images are prefetched in the background without the marker", m.name, holder)
+		return nil, false
+	}
+}
 
-	prefetchImages = removeDuplicates(prefetchImages)
+// prefetch pulls the images missing on the nodes in the namespace of the run.
+func (s *Suite) prefetch(p *prefetcher, config *Config, r *run, list []string) {
+	if list = s.prepare(p, config, r, list); len(list) == 0 {
+		return
+	}
+	var err = p.prefetch(context.Background(), s.plan(config, list))
+	s.statsWriter(config, p.stats)()
+	require.NoError(s.T(), err)
+}
 
-	tmpDir := uuid.NewString()
-	require.NoError(s.T(), os.MkdirAll(tmpDir, 0750))
-	s.T().Cleanup(func() { _ = os.RemoveAll(tmpDir) })
+// prefetchInBackground starts pulling the images missing on the nodes in the order of priority in the background.
+// finish is called when the prefetch is done.
+func (s *Suite) prefetchInBackground(p *prefetcher, config *Config, r *run, list []string, finish func(completed bool)) {
+	var started bool
+	defer func() {
+		if !started {
+			finish(false)
+		}
+	}()
 
-	r := s.Runner(tmpDir)
+	list, tests := s.orderImages(config, list)
+	if list = s.prepare(p, config, r, list); len(list) == 0 {
+		started = true
+		finish(true)
+		return
+	}
 
-	var daemonSets []string
-	for d := 0; d*config.ImagesPerDaemonset < len(prefetchImages); d++ {
-		var containers string
-		for c := 0; c < config.ImagesPerDaemonset && d*config.ImagesPerDaemonset+c < len(prefetchImages); c++ {
-			containers += container(uuid.NewString(), prefetchImages[d*config.ImagesPerDaemonset+c])
+	var writeStats = s.statsWriter(config, p.stats)
+	b, err := p.prefetchInBackground(s.plan(config, list), config.Parallelism, func(err error) {
+		if err != nil {
+			logrus.Errorf("Background prefetch failed: %v", err.Error())
 		}
+		writeStats()
+		finish(err == nil)
+	})
+	require.NoError(s.T(), err)
+	started = true
+
+	logrus.Info("Images are prefetched in the background, tests wait for their images")
+	b.tests = tests
+	prefetched = b
+	s.T().Cleanup(func() {
+		// Tests of the next suites don't wait for the stopped prefetch
+		b.stop()
+		prefetched = nil
+	})
+}
+
+// statsWriter returns the function writing the pull statistics to the artifacts directory of the suite.
+func (s *Suite) statsWriter(config *Config, stats *pullStats) func() {
+	var dir = filepath.Join(config.ArtifactsDir, s.T().Name())
+	return func() {
+		if err := writeStats(dir, stats.report(s.Repository, s.Version)); err != nil {
+			logrus.Warn(err.Error())
+			return
+		}
+		logrus.Infof("Prefetch statistics are written to %v", dir)
+	}
+}
+
+// orderImages orders the images by priority for the background prefetch and returns images of the tests by the test key.
+func (s *Suite) orderImages(config *Config, list []string) ([]string, map[string][]string) {
+	_, f, rw := s.filters(config)
+	var tests = s.testImages(context.Background(), newTestSelector(), f, rw)
+	list, err := prioritize(list, config.Priority, tests)
+	require.NoError(s.T(), err)
+	return list, tests
+}
+
+// BeforeTest waits until the images of the test are prefetched if the prefetch runs in the background. Waits for all
+// images if the images of the test are unknown.
+func (s *Suite) BeforeTest(_, testName string) {
+	if prefetched == nil {
+		return
+	}
+	var match = func(string) bool { return true }
+	if list, ok := prefetched.testImages(s.T().Name(), testName); ok {
+		match = imageMatcher(list)
+	}
+	require.NoError(s.T(), prefetched.wait(context.Background(), match))
+}
 
-		r.Run(createDaemonSet(d, containers))
+// prepare creates the namespace of the run and returns the images missing on the nodes, nil if all images are present.
+func (s *Suite) prepare(p *prefetcher, config *Config, r *run, list []string) []string {
+	if config.NamespaceTTL > 0 {
+		if err := p.collectGarbage(context.Background(), config.NamespaceTTL); err != nil {
+			logrus.Warnf("Stale prefetch namespaces are not deleted: %v", err.Error())
+		}
+	}
 
-		daemonSets = append(daemonSets, fmt.Sprintf("prefetch-%d", d))
+	if config.SkipPresent {
+		missing, err := p.missingImages(context.Background(), list)
+		if err != nil {
+			logrus.Warnf("All images will be prefetched: %v", err.Error())
+		} else {
+			list = missing
+		}
+	}
+	if len(list) == 0 {
+		logrus.Info("All images are present on the nodes, nothing to prefetch")
+		return nil
 	}
 
-	r.Run("kubectl create ns prefetch")
+	var deleteNamespace = func() {
+		if err := p.deleteNamespace(context.Background()); err != nil {
+			logrus.Warn(err.Error())
+		}
+	}
+	var stop = cleanupOnSignal(deleteNamespace)
 	s.T().Cleanup(func() {
-		r.Run("kubectl describe pods -n prefetch")
-		r.Run("kubectl delete ns prefetch")
+		stop()
+		deleteNamespace()
 	})
+	require.NoError(s.T(), p.createNamespace(context.Background()))
+	logrus.Infof("Prefetch run %v uses namespace %v", r.id, p.namespace)
+
+	require.NoError(s.T(), p.copyPullSecrets(context.Background(), config.PullSecretsNamespace, config.PullSecrets))
+	return list
+}
+
+// plan groups the images into DaemonSets by count in the order of the images or, if PlanBySize is set, by the size
+// budget with the largest images first. Groups packed for the background prefetch are ordered by the images priority.
+func (s *Suite) plan(config *Config, list []string) [][]string {
+	if !config.PlanBySize {
+		return chunkImages(list, config.ImagesPerDaemonset)
+	}
+	budget, err := resource.ParseQuantity(config.DaemonsetBudget)
+	require.NoError(s.T(), err)
+
+	var c = &registryClient{client: http.DefaultClient, platform: config.Platform}
+	if config.Cache {
+		c.cacheDir = cacheDir(config.CacheDir)
+	}
+	var plan = packImages(list, c.sizes(context.Background(), list), budget.Value(), config.ImagesPerDaemonset)
+	if config.Background {
+		plan = orderGroups(plan, list)
+	}
+	for i, group := range plan {
+		logrus.Infof("Daemonset prefetch-%v pulls %v", i, strings.Join(group, ", "))
+	}
+	return plan
+}
+
+// filters returns the profile, the filter and the rewriter of the images.
+func (s *Suite) filters(config *Config) (*profile, *images.Filter, *images.Rewriter) {
+	if config.Profile == "" {
+		config.Profile = s.Profile
+	}
+	profile, err := getProfile(config.Profile)
+	require.NoError(s.T(), err)
+
+	var exclude = profile.exclude
+	if config.Exclude != nil {
+		exclude = *config.Exclude
+	}
+	filter, err := images.NewFilter(config.Include, exclude, config.FilterFile)
+	require.NoError(s.T(), err)
+
+	rewriter, err := images.NewRewriter(config.ImageMirrors, config.ImageRewrites)
+	require.NoError(s.T(), err)
+
+	return profile, filter, rewriter
+}
+
+// images returns filtered and rewritten images of the sources and their source files by the image. Source files are
+// nil if the images are loaded from the cache.
+func (s *Suite) images(config *Config) ([]string, map[string][]string) {
+	profile, filter, rewriter := s.filters(config)
 
-	var wg sync.WaitGroup
-	for _, daemonSet := range daemonSets {
-		wg.Add(1)
-		go func(daemonSet string) {
-			defer wg.Done()
+	var sourcesURLs, selected = s.SourcesURLs, false
+	if len(sourcesURLs) == 0 {
+		sourcesURLs, selected = s.profileSourcesURLs(profile, config.SelectedTests)
+	}
+
+	var selection = &selectionEntry{Version: s.Version, Sources: sourcesURLs, PathRules: filter.PathRules()}
+	var cache = selected && config.Cache
+	if cache {
+		var err error
+		if selection.Digest, err = manifestsDigest(s.Dir); err != nil {
+			logrus.Warnf("Images of the selected tests are not cached: %v", err.Error())
+			cache = false
+		}
+	}
+	if cache && !config.DryRun {
+		if cached := loadSelection(cacheDir(config.CacheDir), s.T().Name()); cached.equal(selection) {
+			logrus.Infof("Images of the selected tests are loaded from the cache")
+			return rewriter.RewriteAll(filter.FilterImages(cached.Images)), nil
+		}
+	}
 
-			dr := s.Runner(tmpDir)
-			dr.Run(fmt.Sprintf("kubectl -n prefetch apply -f %s.yaml", daemonSet))
-			dr.Run(fmt.Sprintf("kubectl -n prefetch rollout status daemonset/%s --timeout=%s", daemonSet, config.Timeout))
-			dr.Run(fmt.Sprintf("kubectl -n prefetch delete -f %s.yaml", daemonSet))
-		}(daemonSet)
+	var opts = []images.Option{images.WithGithubToken(config.GithubToken)}
+	if config.Cache {
+		opts = append(opts, images.WithCacheDir(cacheDir(config.CacheDir)))
 	}
-	wg.Wait()
+
+	list, err := images.ReteriveListContext(context.Background(), sourcesURLs, func(s string) bool {
+		return strings.HasSuffix(s, ".yaml") && filter.MatchPath(s)
+	}, opts...)
+	if config.Strict {
+		require.NoError(s.T(), err)
+	} else if err != nil {
+		logrus.Warnf("Some images will not be prefetched: %v", err.Error())
+	}
+
+	selection.Images = images.Deduplicate(list.Images)
+	if cache && err == nil {
+		if storeErr := storeSelection(cacheDir(config.CacheDir), s.T().Name(), selection); storeErr != nil {
+			logrus.Warnf("Failed to cache images of the selected tests: %v", storeErr.Error())
+		}
+	}
+
+	return rewriter.RewriteAll(filter.FilterImages(selection.Images)), sourceFiles(list.Files, filter, rewriter)
 }
 
-func removeDuplicates(source []string) []string {
-	var allKeys = make(map[string]bool)
-	var result []string
-	for _, item := range source {
-		if _, value := allKeys[item]; !value {
-			allKeys[item] = true
-			result = append(result, item)
+// profileSourcesURLs returns the profile sources. If the local repository exists and selected is true, returns
+// sources of the tests selected to run only and true.
+func (s *Suite) profileSourcesURLs(profile *profile, selected bool) ([]string, bool) {
+	if s.Dir != "" {
+		if info, err := os.Stat(s.Dir); err == nil && info.IsDir() {
+			if selected {
+				if sourcesURLs := s.selectedSourcesURLs(); sourcesURLs != nil {
+					return sourcesURLs, true
+				}
+			}
+			logrus.Infof("Images are searched in the local repository %v", s.Dir)
+			return profile.localSourcesURLs(s.Dir), false
 		}
+		logrus.Infof("Local repository %v is not found, images are searched in github %v", s.Dir, s.Repository)
+	}
+	return profile.sourcesURLs(s.Repository, s.Version), false
+}
+
+// selectedSourcesURLs returns external images and directories applied by the selected tests. Returns nil if
+// the tests are not selected or the examples cannot be parsed.
+func (s *Suite) selectedSourcesURLs() []string {
+	examples, err := parseExamples(s.Dir)
+	if err != nil {
+		logrus.Warnf("Images of all tests are prefetched: %v", err.Error())
+		return nil
+	}
+	var dirs = selectDirs(examples, newTestSelector())
+	if dirs == nil {
+		return nil
+	}
+
+	var p = &profile{paths: []string{images.ExternalImagesPath}}
+	for _, dir := range dirs {
+		logrus.Infof("Images are searched in %v applied by the selected tests", dir)
+		if rel, err := filepath.Rel(s.Dir, dir); err == nil {
+			p.paths = append(p.paths, filepath.ToSlash(rel))
+		}
+	}
+	return p.localSourcesURLs(s.Dir)
+}
+
+func cacheDir(dir string) string {
+	if dir != "" {
+		return dir
+	}
+	if userCacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(userCacheDir, "networkservicemesh", "integration-tests", "prefetch")
 	}
-	return result
+	return filepath.Join(os.TempDir(), "networkservicemesh-prefetch-cache")
 }
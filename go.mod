@@ -3,9 +3,9 @@ module github.com/networkservicemesh/integration-tests
 go 1.16
 
 require (
-	github.com/google/uuid v1.2.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/networkservicemesh/gotestmd v0.0.0-20211116145945-871d2aaf07ab
+	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/sys v0.0.0-20211116061358-0a5406a5449c // indirect
@@ -13,4 +13,5 @@ require (
 	k8s.io/api v0.20.5
 	k8s.io/apimachinery v0.20.5
 	k8s.io/client-go v0.20.5
+	sigs.k8s.io/yaml v1.2.0
 )